@@ -0,0 +1,235 @@
+package memstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// alertTopics are the topics that alert rules are evaluated on.
+const alertTopics = topicMemStats | topicGC | topicGoroutines
+
+// alertQueue is the number of notifications waiting to be delivered. When it
+// is full, new notifications are dropped.
+const alertQueue = 64
+
+// alertClient delivers notifications to the webhook.
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// Rule is an alert condition, evaluated on every tick. Rules must be created
+// using HeapAllocAbove, GCPauseP99Above or GoroutineGrowth; their fields may
+// then be adjusted.
+type Rule struct {
+	// Name identifies the rule in notifications.
+	Name string
+	// Threshold is the value above which the rule fires.
+	Threshold float64
+	// Resolve is the value below which a firing rule resolves. It is at
+	// most Threshold; a lower value keeps alerts from flapping when the
+	// watched value hovers around Threshold. Zero means Threshold.
+	Resolve float64
+	// For is the number of consecutive ticks that the value must be above
+	// Threshold before the rule fires, and below Resolve before it
+	// resolves. Zero means one tick.
+	For int
+
+	// value reads the watched value from smp. It returns false if smp
+	// does not hold enough data.
+	value func(st *ruleState, smp *sample) (float64, bool)
+}
+
+// HeapAllocAbove returns a rule that fires when MemStats.HeapAlloc stays
+// above bytes for ticks consecutive ticks.
+func HeapAllocAbove(bytes uint64, ticks int) Rule {
+	return Rule{
+		Name:      fmt.Sprintf("HeapAlloc above %d bytes", bytes),
+		Threshold: float64(bytes),
+		For:       ticks,
+		value: func(st *ruleState, smp *sample) (float64, bool) {
+			if smp.MemStats == nil {
+				return 0, false
+			}
+			return float64(smp.MemStats.HeapAlloc), true
+		},
+	}
+}
+
+// GCPauseP99Above returns a rule that fires when the 99th percentile of the
+// most recent garbage collection pauses, of which the runtime keeps up to
+// 256, is above d.
+func GCPauseP99Above(d time.Duration) Rule {
+	return Rule{
+		Name:      fmt.Sprintf("GC pause p99 above %s", d),
+		Threshold: float64(d),
+		value: func(st *ruleState, smp *sample) (float64, bool) {
+			if smp.GCStats == nil || len(smp.GCStats.Pause) == 0 {
+				return 0, false
+			}
+			pauses := append([]time.Duration(nil), smp.GCStats.Pause...)
+			sort.Slice(pauses, func(i, j int) bool { return pauses[i] < pauses[j] })
+			return float64(pauses[(len(pauses)-1)*99/100]), true
+		},
+	}
+}
+
+// GoroutineGrowth returns a rule that fires when the number of goroutines
+// grew by more than percent over the last minute.
+func GoroutineGrowth(percent float64) Rule {
+	return Rule{
+		Name:      fmt.Sprintf("goroutines growing by %g%%/min", percent),
+		Threshold: percent,
+		value: func(st *ruleState, smp *sample) (float64, bool) {
+			if smp.NumGo == 0 {
+				return 0, false
+			}
+			st.points = append(st.points, rulePoint{smp.Time, float64(smp.NumGo)})
+			var n int
+			for n < len(st.points)-1 && smp.Time.Sub(st.points[n+1].time) >= time.Minute {
+				n++
+			}
+			st.points = st.points[n:]
+			first := st.points[0]
+			if smp.Time.Sub(first.time) < time.Minute || first.value == 0 {
+				return 0, false
+			}
+			return 100 * (float64(smp.NumGo) - first.value) / first.value, true
+		},
+	}
+}
+
+// Alert is a notification sent when a rule starts or stops firing.
+type Alert struct {
+	// Rule is the name of the rule.
+	Rule string
+	// State is "firing" or "resolved".
+	State string
+	// Value is the value that changed the state of the rule.
+	Value     float64
+	Threshold float64
+	Time      time.Time
+	// Since is when the rule started firing.
+	Since time.Time
+}
+
+// ruleState is the state of a rule's evaluation.
+type ruleState struct {
+	rule   Rule
+	firing bool
+	since  time.Time
+	count  int         // consecutive ticks towards changing state
+	points []rulePoint // used by rules which look at past values
+}
+
+type rulePoint struct {
+	time  time.Time
+	value float64
+}
+
+// eval evaluates the rule on smp and returns a notification if its state
+// changed.
+func (st *ruleState) eval(smp *sample) *Alert {
+	if st.rule.value == nil {
+		return nil
+	}
+	v, ok := st.rule.value(st, smp)
+	if !ok {
+		return nil
+	}
+	resolve := st.rule.Resolve
+	if resolve == 0 || resolve > st.rule.Threshold {
+		resolve = st.rule.Threshold
+	}
+	if (!st.firing && v > st.rule.Threshold) || (st.firing && v < resolve) {
+		st.count++
+	} else {
+		st.count = 0
+	}
+	ticks := st.rule.For
+	if ticks < 1 {
+		ticks = 1
+	}
+	if st.count < ticks {
+		return nil
+	}
+	st.count = 0
+	st.firing = !st.firing
+	a := Alert{
+		Rule:      st.rule.Name,
+		State:     "resolved",
+		Value:     v,
+		Threshold: st.rule.Threshold,
+		Time:      smp.Time,
+		Since:     st.since,
+	}
+	if st.firing {
+		st.since = smp.Time
+		a.State, a.Since = "firing", smp.Time
+	}
+	return &a
+}
+
+// evaluate evaluates the server's rules on the samples of sub and queues
+// notifications until the server shuts down.
+func (s *Server) evaluate(sub *subscription) {
+	defer s.smp.unsubscribe(sub)
+	s.smp.update(sub, func(sub *subscription) { sub.topics = alertTopics })
+	rules := make([]ruleState, len(s.rules))
+	for i, r := range s.rules {
+		rules[i].rule = r
+	}
+	alerts := make(chan Alert, alertQueue)
+	defer close(alerts)
+	go s.notify(alerts)
+	for {
+		select {
+		case smp := <-sub.C:
+			for i := range rules {
+				a := rules[i].eval(smp)
+				if a == nil {
+					continue
+				}
+				select {
+				case alerts <- *a:
+				default:
+					s.logf("alert %q dropped: too many pending notifications", a.Rule)
+				}
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// notify delivers alerts to the server's alert handler and webhook, in order.
+func (s *Server) notify(alerts <-chan Alert) {
+	for a := range alerts {
+		if s.alertHandler != nil {
+			s.alertHandler(a)
+		}
+		if s.alertWebhook != "" {
+			if err := postAlert(s.alertWebhook, a); err != nil {
+				s.logf("alert webhook: %s", err)
+			}
+		}
+	}
+}
+
+// postAlert posts a as JSON to url.
+func postAlert(url string, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	resp, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package memstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRuleHysteresis(t *testing.T) {
+	r := HeapAllocAbove(100, 2)
+	r.Resolve = 80
+	st := ruleState{rule: r}
+	start := time.Unix(0, 0)
+	for i, tt := range []struct {
+		heapAlloc uint64
+		want      string // state of the alert sent, if any
+	}{
+		{150, ""},
+		{50, ""}, // not above the threshold for 2 ticks in a row
+		{150, ""},
+		{150, "firing"},
+		{200, ""},
+		{90, ""}, // below the threshold but not the resolve value
+		{70, ""},
+		{90, ""},
+		{70, ""},
+		{70, "resolved"},
+		{90, ""},
+	} {
+		smp := &sample{
+			Time:     start.Add(time.Duration(i) * time.Second),
+			MemStats: &runtime.MemStats{HeapAlloc: tt.heapAlloc},
+		}
+		a := st.eval(smp)
+		switch {
+		case a == nil && tt.want == "":
+		case a == nil:
+			t.Errorf("tick %d: no alert, want %s", i, tt.want)
+		case a.State != tt.want:
+			t.Errorf("tick %d: alert %+v, want state %q", i, a, tt.want)
+		case a.Value != float64(tt.heapAlloc) || a.Threshold != 100:
+			t.Errorf("tick %d: alert has value %g and threshold %g, want %d and 100", i, a.Value, a.Threshold, tt.heapAlloc)
+		case !a.Since.Equal(start.Add(3 * time.Second)):
+			t.Errorf("tick %d: alert fired since %s, want %s", i, a.Since, start.Add(3*time.Second))
+		}
+	}
+	if a := st.eval(&sample{Time: start}); a != nil {
+		t.Errorf("alert on a sample without MemStats: %+v", a)
+	}
+}
+
+func TestGoroutineGrowth(t *testing.T) {
+	st := ruleState{rule: GoroutineGrowth(40)}
+	start := time.Unix(0, 0)
+	for i, tt := range []struct {
+		numGo int
+		want  string
+		value float64
+	}{
+		{100, "", 0},
+		{110, "", 0},
+		{120, "", 0}, // less than a minute of samples
+		{150, "firing", 50},
+		{115, "resolved", 100 * (115.0 - 110) / 110}, // compared to the sample a minute earlier
+		{200, "firing", 100 * (200.0 - 120) / 120},
+	} {
+		smp := &sample{Time: start.Add(time.Duration(i) * 20 * time.Second), NumGo: tt.numGo}
+		a := st.eval(smp)
+		switch {
+		case a == nil && tt.want == "":
+		case a == nil:
+			t.Errorf("%s: no alert, want %s", smp.Time.Sub(start), tt.want)
+		case a.State != tt.want || a.Value != tt.value:
+			t.Errorf("%s: alert %s at %g, want %q at %g", smp.Time.Sub(start), a.State, a.Value, tt.want, tt.value)
+		}
+	}
+	if len(st.points) != 4 {
+		t.Errorf("kept %d points, want the 4 within the last minute", len(st.points))
+	}
+}
+
+func TestAlertNotify(t *testing.T) {
+	var posted []Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); r.Method != http.MethodPost || ct != "application/json" {
+			t.Errorf("got %s request with content type %q, want a JSON POST", r.Method, ct)
+		}
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Error(err)
+		}
+		posted = append(posted, a)
+	}))
+	defer srv.Close()
+
+	var handled []Alert
+	s := &Server{
+		alertWebhook: srv.URL,
+		alertHandler: func(a Alert) { handled = append(handled, a) },
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	sent := []Alert{
+		{Rule: "heap", State: "firing", Value: 150, Threshold: 100, Time: now, Since: now},
+		{Rule: "heap", State: "resolved", Value: 70, Threshold: 100, Time: now.Add(time.Minute), Since: now},
+	}
+	alerts := make(chan Alert, len(sent))
+	for _, a := range sent {
+		alerts <- a
+	}
+	close(alerts)
+	s.notify(alerts)
+	for name, got := range map[string][]Alert{"posted": posted, "handled": handled} {
+		if len(got) != len(sent) {
+			t.Errorf("%s %d alerts, want %d", name, len(got), len(sent))
+			continue
+		}
+		for i := range got {
+			if got[i] != sent[i] {
+				t.Errorf("%s alert %d is %+v, want %+v", name, i, got[i], sent[i])
+			}
+		}
+	}
+}
+
+func TestPostAlertError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	if err := postAlert(srv.URL, Alert{Rule: "heap"}); err == nil {
+		t.Error("no error for a webhook which failed")
+	}
+}
@@ -0,0 +1,42 @@
+package memstats
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// auditRecord is an entry of the audit log.
+type auditRecord struct {
+	Time   time.Time
+	User   string `json:",omitempty"`
+	Role   Role
+	Remote string
+	// Action is the request type on the websocket feed.
+	Action string
+	Args   json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+	Result interface{}     `json:",omitempty"`
+}
+
+// audit appends rec to the server's audit log, if it keeps one.
+func (s *Server) audit(rec auditRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		s.logf("audit: %s", err)
+		return
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	if s.auditLog == nil {
+		return
+	}
+	if _, err := s.auditLog.Write(append(b, '\n')); err != nil {
+		s.logf("audit: %s", err)
+	}
+}
+
+// openAuditFile opens the file that the audit log is appended to.
+func openAuditFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
@@ -0,0 +1,86 @@
+package memstats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestAuditFileError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "audit.jsonl")
+	s := NewServer(ListenAddr("localhost:0"), AuditFile(path))
+	if err := s.Start(context.Background()); err == nil {
+		s.Shutdown(context.Background())
+		t.Fatal("server started without its audit file")
+	}
+}
+
+func TestAuditFileErrorMounted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "audit.jsonl")
+	// mounted on another server, so Start is never called
+	s := NewServer(Control(), AuditFile(path))
+	defer s.Shutdown(context.Background())
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/memstats-feed", "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for _, typ := range []string{"gc", "pause"} {
+		if err := websocket.JSON.Send(ws, request{Type: typ, ID: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replies := map[string]reply{}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(replies) < 2 {
+		var rep reply
+		if err := websocket.JSON.Receive(ws, &rep); err != nil {
+			t.Fatal(err)
+		}
+		if rep.Type == "reply" {
+			replies[rep.ID] = rep
+		}
+	}
+	if replies["gc"].Error == "" {
+		t.Error("gc ran without its audit file")
+	}
+	if replies["pause"].Error != "" {
+		t.Errorf("pause, which needs the viewer role, failed: %s", replies["pause"].Error)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s := NewServer(ListenAddr("localhost:0"), AuditFile(path))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.audit(auditRecord{Time: time.Now(), User: "oncall", Role: RoleOperator, Action: "gc"})
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// records after the file was closed are dropped
+	s.audit(auditRecord{Time: time.Now(), User: "oncall", Role: RoleOperator, Action: "gc"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec struct{ User, Role, Action string }
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("audit file holds %q: %v", data, err)
+	}
+	if rec.User != "oncall" || rec.Role != "operator" || rec.Action != "gc" {
+		t.Errorf("audit record is %+v, want a gc by oncall, an operator", rec)
+	}
+}
@@ -0,0 +1,108 @@
+package memstats
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Role is the access level of a client. Each role may do everything that the
+// roles below it may do.
+type Role int
+
+const (
+	// RoleViewer may read the feed, profiles and dumps.
+	RoleViewer Role = iota + 1
+	// RoleOperator may also capture CPU profiles, run the garbage
+	// collector and return memory to the operating system.
+	RoleOperator
+	// RoleAdmin may also change the garbage collector's settings.
+	RoleAdmin
+)
+
+// String returns the name of the role: viewer, operator or admin.
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding the role by name.
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Credential maps a bearer token to a role. Name identifies its holder in the
+// audit log.
+type Credential struct {
+	Name  string
+	Token string
+	Role  Role
+}
+
+// identity is who sent a request.
+type identity struct {
+	Name string
+	Role Role
+}
+
+// commandRoles holds the role needed by requests on the websocket feed.
+// Requests which are not listed need RoleViewer.
+var commandRoles = map[string]Role{
+	"cpuprofile":     RoleOperator,
+	"gc":             RoleOperator,
+	"freeosmemory":   RoleOperator,
+	"setgcpercent":   RoleAdmin,
+	"setmemorylimit": RoleAdmin,
+}
+
+// token returns the bearer token of r, read from the Authorization header or,
+// for browsers which can not set headers on websockets, from the "token"
+// query parameter.
+func token(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+			return strings.TrimSpace(h[7:])
+		}
+		return ""
+	}
+	return r.URL.Query().Get("token")
+}
+
+// identify returns who sent r, based on the token it carries. It reports
+// false if the server has credentials and none of them match. Without
+// credentials, every request is made as RoleAdmin. Tokens are compared in
+// constant time, and all of them are compared so that the time taken does
+// not tell which one matched.
+func (s *Server) identify(r *http.Request) (identity, bool) {
+	creds := s.credentials()
+	if len(creds) == 0 {
+		return identity{Role: RoleAdmin}, true
+	}
+	tok := []byte(token(r))
+	match := -1
+	for i, c := range creds {
+		eq := subtle.ConstantTimeCompare(tok, []byte(c.Token))
+		match = subtle.ConstantTimeSelect(eq, i, match)
+	}
+	if match < 0 || len(tok) == 0 {
+		return identity{}, false
+	}
+	return identity{Name: creds[match].Name, Role: creds[match].Role}, true
+}
+
+// credentials returns the server's credentials, including its Tokens, which
+// are given RoleAdmin.
+func (s *Server) credentials() []Credential {
+	creds := s.creds
+	for _, t := range s.tokens {
+		creds = append(creds[:len(creds):len(creds)], Credential{Token: t, Role: RoleAdmin})
+	}
+	return creds
+}
@@ -0,0 +1,116 @@
+package memstats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestIdentify(t *testing.T) {
+	viewer := Credential{Name: "dashboard", Token: "view", Role: RoleViewer}
+	for _, tt := range []struct {
+		name   string
+		opts   []func(*Server)
+		header string
+		query  string
+		ok     bool
+		want   identity
+	}{
+		{"no credentials", nil, "", "", true, identity{Role: RoleAdmin}},
+		{"header", []func(*Server){Tokens("new")}, "Bearer new", "", true, identity{Role: RoleAdmin}},
+		{"header scheme case", []func(*Server){Tokens("new")}, "bearer new", "", true, identity{Role: RoleAdmin}},
+		{"query", []func(*Server){Tokens("new")}, "", "token=new", true, identity{Role: RoleAdmin}},
+		{"header before query", []func(*Server){Tokens("new")}, "Bearer old", "token=new", false, identity{}},
+		{"basic auth", []func(*Server){Tokens("new")}, "Basic bmV3", "", false, identity{}},
+		{"wrong token", []func(*Server){Tokens("new")}, "Bearer wrong", "", false, identity{}},
+		{"prefix of token", []func(*Server){Tokens("new")}, "Bearer ne", "", false, identity{}},
+		{"missing token", []func(*Server){Tokens("new")}, "", "", false, identity{}},
+		{"rotation, new token", []func(*Server){Tokens("new", "old")}, "Bearer new", "", true, identity{Role: RoleAdmin}},
+		{"rotation, old token", []func(*Server){Tokens("new", "old")}, "", "token=old", true, identity{Role: RoleAdmin}},
+		{"rotation, retired token", []func(*Server){Tokens("new", "old")}, "Bearer older", "", false, identity{}},
+		// as with Tokens(os.Getenv("NEW"), os.Getenv("UNSET"))
+		{"empty token in list, missing token", []func(*Server){Tokens("new", "")}, "", "", false, identity{}},
+		{"empty token in list, empty header", []func(*Server){Tokens("new", "")}, "Bearer ", "", false, identity{}},
+		{"empty token in list, empty query", []func(*Server){Tokens("new", "")}, "", "token=", false, identity{}},
+		{"empty token in list, token", []func(*Server){Tokens("new", "")}, "Bearer new", "", true, identity{Role: RoleAdmin}},
+		{"only empty tokens", []func(*Server){Tokens("", "")}, "", "", false, identity{}},
+		{"credential", []func(*Server){Tokens("new"), Credentials(viewer)}, "Bearer view", "", true, identity{Name: "dashboard", Role: RoleViewer}},
+		{"token with credentials", []func(*Server){Tokens("new"), Credentials(viewer)}, "", "token=new", true, identity{Role: RoleAdmin}},
+	} {
+		s := NewServer(tt.opts...)
+		u := "/memstats-history"
+		if tt.query != "" {
+			u += "?" + tt.query
+		}
+		r := httptest.NewRequest("GET", u, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		id, ok := s.identify(r)
+		if ok != tt.ok || id != tt.want {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", tt.name, id, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	s := NewServer(Tokens("new", "old"))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		path, token string
+		code        int
+	}{
+		{"/memstats-history", "", http.StatusUnauthorized},
+		{"/memstats-history", "wrong", http.StatusUnauthorized},
+		{"/memstats-goroutines", "", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/memstats-goroutines", "old", http.StatusOK},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.code {
+			t.Errorf("%s with token %q: got status %d, want %d", tt.path, tt.token, res.StatusCode, tt.code)
+		}
+		if tt.code == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s with token %q: no WWW-Authenticate header", tt.path, tt.token)
+		}
+	}
+
+	feed := "ws" + strings.TrimPrefix(srv.URL, "http") + "/memstats-feed"
+	for _, tt := range []struct {
+		query string
+		ok    bool
+	}{
+		{"", false},
+		{"?token=wrong", false},
+		{"?token=", false},
+		{"?token=new", true},
+	} {
+		ws, err := websocket.Dial(feed+tt.query, "", "http://localhost/")
+		if (err == nil) != tt.ok {
+			t.Errorf("feed%s: got error %v, want connected %v", tt.query, err, tt.ok)
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), "bad status") {
+				t.Errorf("feed%s: got error %v, want a refused upgrade", tt.query, err)
+			}
+			continue
+		}
+		ws.Close()
+	}
+}
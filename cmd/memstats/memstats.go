@@ -1,32 +1,146 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 var (
-	laddr = flag.String("http", ":8080", "HTTP address to listen on")
-	saddr = flag.String("sock", "localhost:6061", "Adress the WebSockets listen on.")
+	laddr  = flag.String("http", ":8080", "HTTP address to listen on")
+	saddr  = flag.String("sock", "localhost:6061", "Adress the WebSockets listen on, or unix:/path of a unix socket.")
+	spath  = flag.String("prefix", "", "Path prefix the memstats server is mounted under.")
+	token  = flag.String("token", "", "Token to authenticate with, if the memstats server requires one.")
+	useTLS = flag.Bool("tls", false, "Connect to the memstats server using TLS. Detected on every page load when not set.")
 )
 
+// viewerConfig holds the values the viewer template needs to connect to the feed.
+type viewerConfig struct {
+	// Addr is the host[:port] of the memstats server.
+	Addr string
+	// Prefix is the path that the memstats server is mounted under.
+	Prefix string
+	// Token authenticates the viewer with the memstats server.
+	Token string
+	// TLS is set if the memstats server serves over TLS.
+	TLS bool
+}
+
+// proxyPrefix is the path under which the feed of a memstats server listening
+// on a unix socket is proxied, as browsers can not connect to unix sockets.
+const proxyPrefix = "/memstats"
+
+// cleanPrefix returns the path prefix p with a leading slash and without a
+// trailing one.
+func cleanPrefix(p string) string {
+	p = strings.TrimSuffix(p, "/")
+	if p != "" && !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// unixProxy returns a handler which proxies requests to the memstats server
+// listening on the unix socket at path.
+func unixProxy(path string) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "unix", Path: cleanPrefix(*spath)})
+	proxy.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return http.StripPrefix(proxyPrefix, proxy)
+}
+
+// isLoopback reports whether the host of addr, a host:port, only accepts
+// connections from the local machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// detectTLS reports whether the memstats server at addr serves over TLS, by
+// trying a TLS handshake with it. The certificate of the server is not
+// verified, as the browser does that when it connects.
+func detectTLS(addr string) bool {
+	d := net.Dialer{Timeout: 2 * time.Second}
+	conn, err := tls.DialWithDialer(&d, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		conn.Close()
+		return true
+	}
+	// a server which requires a client certificate aborts the handshake
+	// with an alert
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "remote error"
+}
+
 func serveHTTP(w http.ResponseWriter, req *http.Request) {
-	if err := tpl.ExecuteTemplate(w, "main", *saddr); err != nil {
+	cfg := viewerConfig{
+		Addr:   *saddr,
+		Prefix: cleanPrefix(*spath),
+		Token:  *token,
+		TLS:    *useTLS,
+	}
+	if strings.HasPrefix(*saddr, "unix:") {
+		cfg.Addr, cfg.Prefix, cfg.TLS = req.Host, proxyPrefix, false
+	} else if !cfg.TLS {
+		cfg.TLS = detectTLS(*saddr)
+	}
+	if err := tpl.ExecuteTemplate(w, "main", cfg); err != nil {
 		fmt.Fprintf(w, "Error parsing template: %s", err)
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "record":
+			record(os.Args[2:])
+			return
+		case "replay":
+			replay(os.Args[2:])
+			return
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: memstats [flags]\n       memstats record [flags]\n       memstats replay [flags] file\n\nFlags of the viewer:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	hst, _, err := net.SplitHostPort(*saddr)
-	if len(hst) == 0 || err != nil {
-		log.Fatal("sockaddr must be host[:port]", err)
+	if path := strings.TrimPrefix(*saddr, "unix:"); path != *saddr {
+		if !isLoopback(*laddr) {
+			// the proxy would expose the socket to the network
+			log.Fatal("-http must be a loopback address, such as localhost:8080, when -sock is a unix socket")
+		}
+		http.Handle(proxyPrefix+"/", unixProxy(path))
+	} else if hst, _, err := net.SplitHostPort(*saddr); len(hst) == 0 || err != nil {
+		log.Fatal("sockaddr must be host[:port] or unix:/path", err)
+	}
+	if *token != "" && !isLoopback(*laddr) {
+		// the page holds the token, and is served without authentication
+		log.Fatal("-http must be a loopback address, such as localhost:8080, when -token is set")
 	}
 	http.HandleFunc("/", serveHTTP)
-	err = http.ListenAndServe(*laddr, nil)
+	err := http.ListenAndServe(*laddr, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
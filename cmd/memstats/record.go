@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// recordedMessage is a line of a recording: a message of the feed along with
+// the time it was received.
+type recordedMessage struct {
+	Time    time.Time
+	Message json.RawMessage
+}
+
+// record implements "memstats record", which writes the feed of a memstats
+// server to a file, one recordedMessage per line.
+func record(args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	sock := fs.String("sock", "localhost:6061", "Address of the memstats server, or unix:/path of a unix socket.")
+	path := fs.String("prefix", "", "Path prefix the memstats server is mounted under.")
+	tok := fs.String("token", "", "Token to authenticate with, if the memstats server requires one.")
+	secure := fs.Bool("tls", false, "Connect to the memstats server using TLS.")
+	caFile := fs.String("cacert", "", "PEM file of the authorities to verify the server's certificate with, instead of the system's. Implies -tls.")
+	certFile := fs.String("cert", "", "PEM file of the client certificate, for servers which require one. Implies -tls.")
+	keyFile := fs.String("key", "", "PEM file of the client certificate's key.")
+	out := fs.String("o", "", "File to write the recording to. Defaults to standard output.")
+	dur := fs.Duration("duration", 0, "Stop recording after this long. Records until interrupted by default.")
+	fs.Parse(args)
+
+	var tlsConfig *tls.Config
+	if *secure || *caFile != "" || *certFile != "" {
+		var err error
+		if tlsConfig, err = clientTLSConfig(*caFile, *certFile, *keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ws, err := dialFeed(*sock, cleanPrefix(*path), *tok, tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ws.Close()
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *dur > 0 {
+		ws.SetReadDeadline(time.Now().Add(*dur))
+	}
+	enc := json.NewEncoder(w)
+	var n int
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				if err != io.EOF {
+					log.Print(err)
+				}
+			}
+			break
+		}
+		if err := enc.Encode(recordedMessage{Time: time.Now(), Message: json.RawMessage(msg)}); err != nil {
+			log.Fatal(err)
+		}
+		n++
+	}
+	fmt.Fprintf(os.Stderr, "recorded %d messages\n", n)
+}
+
+// clientTLSConfig returns the configuration to connect to a memstats server
+// over TLS with. The server's certificate is verified using the authorities
+// in caFile, or the system's if it is empty. The certificate in certFile and
+// keyFile, if set, is presented to servers which require one.
+func clientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	var cfg tls.Config
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return &cfg, nil
+}
+
+// dialFeed connects to the feed of the memstats server at addr, which is a
+// host:port or the unix:/path of a unix socket, over TLS if tlsConfig is set.
+func dialFeed(addr, prefix, tok string, tlsConfig *tls.Config) (*websocket.Conn, error) {
+	u := url.URL{Scheme: "ws", Host: addr, Path: prefix + "/memstats-feed"}
+	if tlsConfig != nil {
+		u.Scheme = "wss"
+	}
+	if tok != "" {
+		u.RawQuery = url.Values{"token": {tok}}.Encode()
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	if path != addr {
+		u.Host = "localhost"
+	}
+	cfg, err := websocket.NewConfig(u.String(), "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	cfg.TlsConfig = tlsConfig
+	if path == addr {
+		return websocket.DialConfig(cfg)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		c := tlsConfig.Clone()
+		c.ServerName = "localhost"
+		conn = tls.Client(conn, c)
+	}
+	ws, err := websocket.NewClient(cfg, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// replayRequest is a request sent by a client of a replayed feed.
+type replayRequest struct {
+	Type string
+	ID   string
+}
+
+// replayReply is sent in response to every request, in the format of the
+// memstats server's replies.
+type replayReply struct {
+	Type    string // always "reply"
+	ID      string `json:",omitempty"`
+	Request string
+	Error   string `json:",omitempty"`
+}
+
+// replay implements "memstats replay", which serves a recording made by
+// "memstats record" as the feed of a memstats server.
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	addr := fs.String("http", "localhost:6061", "HTTP address to serve the recording on.")
+	speed := fs.Float64("speed", 1, "Playback speed, such as 10 to replay ten times faster.")
+	loop := fs.Bool("loop", false, "Start over once the end of the recording is reached.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: memstats replay [flags] file\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	msgs, err := readRecording(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(msgs) == 0 {
+		log.Fatalf("%s holds no messages", fs.Arg(0))
+	}
+	http.Handle("/memstats-feed", websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		playback(ws, msgs, *speed, *loop)
+	}))
+	log.Printf("replaying %d messages on %s", len(msgs), *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// readRecording reads the messages of a recording, leaving out replies to
+// the requests of the recording client.
+func readRecording(path string) ([]recordedMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var msgs []recordedMessage
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var rm recordedMessage
+		if err := json.Unmarshal(sc.Bytes(), &rm); err != nil {
+			return nil, err
+		}
+		var head struct{ Type string }
+		if json.Unmarshal(rm.Message, &head) == nil && head.Type == "reply" {
+			continue
+		}
+		msgs = append(msgs, rm)
+	}
+	return msgs, sc.Err()
+}
+
+// playback sends msgs to ws, spaced as they were recorded divided by speed.
+// Requests are answered so that clients work unchanged: pause and resume
+// control the playback, other requests have no effect.
+func playback(ws *websocket.Conn, msgs []recordedMessage, speed float64, loop bool) {
+	reqs := make(chan replayRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(reqs)
+		for {
+			var req replayRequest
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				if _, ok := err.(*json.SyntaxError); ok {
+					continue
+				}
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var (
+		paused bool
+		i      int
+		timer  = time.NewTimer(0)
+	)
+	defer timer.Stop()
+	for {
+		select {
+		case req, ok := <-reqs:
+			if !ok {
+				return
+			}
+			rep := replayReply{Type: "reply", ID: req.ID, Request: req.Type}
+			switch req.Type {
+			case "pause":
+				paused = true
+			case "resume":
+				if paused {
+					paused = false
+					timer.Reset(0)
+				}
+			case "subscribe":
+			default:
+				rep.Error = "not available in a replay"
+			}
+			if err := websocket.JSON.Send(ws, rep); err != nil {
+				return
+			}
+		case <-timer.C:
+			if paused {
+				continue
+			}
+			if i == len(msgs) {
+				if !loop {
+					continue
+				}
+				i = 0
+			}
+			if err := websocket.Message.Send(ws, string(msgs[i].Message)); err != nil {
+				return
+			}
+			i++
+			if i < len(msgs) {
+				timer.Reset(time.Duration(float64(msgs[i].Time.Sub(msgs[i-1].Time)) / speed))
+			} else if loop {
+				timer.Reset(0)
+			}
+		}
+	}
+}
@@ -4,15 +4,51 @@ import "html/template"
 
 var tpl = template.Must(template.New("name").Parse(`
 {{define "mainJS"}}
-	var ws = new WebSocket("ws://{{.}}/memstats-feed")
+	var token = "{{.Token}}";
+	// Pages served over HTTPS may only connect to secure websockets.
+	var secure = {{.TLS}} || location.protocol === "https:";
+	var ws = new WebSocket(withToken((secure ? "wss" : "ws") + "://{{.Addr}}{{.Prefix}}/memstats-feed"))
+	var httpBase = (secure ? "https" : "http") + "://{{.Addr}}{{.Prefix}}"
 	var tpl = _.template(document.getElementById("ms-viewer-template").innerHTML)
 
+	// Handlers for replies to requests, by request type, and for other
+	// messages, by message type.
+	var replyHandlers = {}, messageHandlers = {};
+
 	// SOCKET /memstats-feeds
 	ws.onopen = function () {
 
 		// ON MESSAGE /memstats-feed
+		// Subscribe to all topics in delta mode, the full sample is
+		// reconstructed from keyframes and deltas.
+		ws.send(JSON.stringify({Type: "subscribe", Args: {
+			Topics: ["memstats", "gc", "profile", "goroutines", "runtime", "contention"],
+			Delta: true
+		}}));
+		var state = null, profiles = {};
+
 		ws.onmessage = function (evt) {
-			var memdata = JSON.parse(evt.data);
+			var msg = JSON.parse(evt.data);
+			if (msg.Type === "delta") {
+				if (!state) {
+					return;
+				}
+				applyDelta(msg);
+			} else if (!msg.Type || msg.Type === "sample") {
+				state = msg;
+				profiles = {};
+				(msg.ProfileKeys || []).forEach(function (key, i) {
+					profiles[key] = msg.Profiles[i];
+				});
+			} else {
+				if (msg.Type === "reply" && replyHandlers[msg.Request]) {
+					replyHandlers[msg.Request](msg);
+				} else if (messageHandlers[msg.Type]) {
+					messageHandlers[msg.Type](msg);
+				}
+				return;
+			}
+			var memdata = JSON.parse(JSON.stringify(state));
 			var humanized = _.clone(memdata);
 			
 			[ // Convert byte values to readable form.
@@ -38,12 +74,215 @@ var tpl = template.Must(template.New("name").Parse(`
 			document.getElementById("ms-viewer").innerHTML = tpl(humanized);
 		}
 
+		// Applies a delta message to the reconstructed sample.
+		function applyDelta(msg) {
+			state.Time = msg.Time;
+			_.each(msg.Set, function (value, path) {
+				setPath(state, path.split("."), value);
+			});
+			_.each(msg.Del, function (path) {
+				setPath(state, path.split("."), undefined);
+			});
+			if (msg.GCPauses && state.GCStats) {
+				state.GCStats.Pause = msg.GCPauses.Pause.concat(state.GCStats.Pause || []).slice(0, msg.GCPauses.Len);
+				state.GCStats.PauseEnd = msg.GCPauses.PauseEnd.concat(state.GCStats.PauseEnd || []).slice(0, msg.GCPauses.Len);
+			}
+			if (msg.Profiles) {
+				_.each(msg.Profiles.Set, function (record, key) {
+					profiles[key] = record;
+				});
+				_.each(msg.Profiles.Del, function (key) {
+					delete profiles[key];
+				});
+				if (msg.Profiles.Order) {
+					state.ProfileKeys = msg.Profiles.Order;
+				}
+				state.Profiles = _.map(state.ProfileKeys, function (key) {
+					return profiles[key];
+				});
+			}
+		}
+
+		// Sets the value at path in obj, creating objects along the way.
+		function setPath(obj, path, value) {
+			for (var i = 0; i < path.length - 1; i++) {
+				if (obj[path[i]] === undefined || obj[path[i]] === null) {
+					obj[path[i]] = /^[0-9]+$/.test(path[i + 1]) ? [] : {};
+				}
+				obj = obj[path[i]];
+			}
+			if (value === undefined) {
+				delete obj[path[path.length - 1]];
+			} else {
+				obj[path[path.length - 1]] = value;
+			}
+		}
+
+		// Heap profile download, readable by go tool pprof
+		document.getElementById("ms-heap").href = withToken(httpBase + "/memstats-heap");
+
+		// CPU profiles
+		var cpuTpl = _.template(document.getElementById("ms-cpu-template").innerHTML);
+		document.getElementById("ms-cpu-button").onclick = function () {
+			var seconds = parseInt(document.getElementById("ms-cpu-seconds").value, 10);
+			ws.send(JSON.stringify({Type: "cpuprofile", Args: {Seconds: seconds}}));
+		};
+		replyHandlers.cpuprofile = function (msg) {
+			if (msg.Error) {
+				document.getElementById("ms-cpu").innerHTML = _.escape(msg.Error);
+			}
+		};
+		messageHandlers.cpuprofile = function (msg) {
+			msg.Download = withToken(httpBase + "/memstats-profile?id=" + encodeURIComponent(msg.Profile || ""));
+			document.getElementById("ms-cpu").innerHTML = cpuTpl(msg);
+		};
+
+		// Heap profile baseline. Samples are then sent with the changes of the
+		// memory profile since the baseline.
+		document.getElementById("ms-baseline-button").onclick = function () {
+			ws.send(JSON.stringify({Type: "baseline"}));
+		};
+		document.getElementById("ms-baseline-clear").onclick = function () {
+			ws.send(JSON.stringify({Type: "baseline", Args: {Clear: true}}));
+		};
+		replyHandlers.baseline = function (msg) {
+			var link = document.getElementById("ms-baseline");
+			if (msg.Error) {
+				console.log("MEMSTAT: baseline:", msg.Error);
+				return;
+			}
+			if (!msg.Result) {
+				link.style.display = "none";
+				return;
+			}
+			link.href = withToken(httpBase + "/memstats-profile?id=" + encodeURIComponent(msg.Result.Profile));
+			link.style.display = "";
+		};
+
+		// Leak suspicions
+		var leakTpl = _.template(document.getElementById("ms-leak-template").innerHTML);
+		messageHandlers.leaksuspect = function (msg) {
+			document.getElementById("ms-leaks").innerHTML = leakTpl(msg);
+		};
+		replyHandlers.leaks = function (msg) {
+			if (msg.Result) {
+				messageHandlers.leaksuspect(msg.Result);
+			}
+		};
+		ws.send(JSON.stringify({Type: "leaks"}));
+
+		// Runtime control, if enabled by the server
+		var controlTpl = _.template(document.getElementById("ms-control-template").innerHTML);
+		document.getElementById("ms-gc-button").onclick = function () {
+			ws.send(JSON.stringify({Type: "gc"}));
+		};
+		document.getElementById("ms-free-button").onclick = function () {
+			ws.send(JSON.stringify({Type: "freeosmemory"}));
+		};
+		// controlError shows why a control command was not sent.
+		var controlError = function (request, error) {
+			document.getElementById("ms-control").innerHTML = controlTpl({Request: request, Error: error});
+		};
+		document.getElementById("ms-gogc-button").onclick = function () {
+			var value = document.getElementById("ms-gogc").value.trim();
+			if (!/^-?[0-9]+$/.test(value)) {
+				return controlError("setgcpercent", "GOGC must be an integer");
+			}
+			ws.send(JSON.stringify({Type: "setgcpercent", Args: {Percent: parseInt(value, 10)}}));
+		};
+		document.getElementById("ms-limit-button").onclick = function () {
+			var value = document.getElementById("ms-limit").value.trim();
+			if (!/^[0-9]+$/.test(value) || parseInt(value, 10) <= 0) {
+				return controlError("setmemorylimit", "the memory limit must be a positive number of bytes");
+			}
+			ws.send(JSON.stringify({Type: "setmemorylimit", Args: {Limit: parseInt(value, 10)}}));
+		};
+		["gc", "freeosmemory", "setgcpercent", "setmemorylimit"].forEach(function (type) {
+			replyHandlers[type] = function (msg) {
+				document.getElementById("ms-control").innerHTML = controlTpl(msg);
+			};
+		});
+
+		// Memory profile order
+		document.getElementById("ms-profile-sort").onchange = function () {
+			ws.send(JSON.stringify({Type: "subscribe", Args: {ProfileSort: this.value}}));
+		};
+
+		// Goroutine dumps
+		var goroutinesTpl = _.template(document.getElementById("ms-goroutines-template").innerHTML);
+		document.getElementById("ms-goroutines-button").onclick = function () {
+			ws.send(JSON.stringify({Type: "goroutines"}));
+		};
+		replyHandlers.goroutines = function (msg) {
+			if (msg.Error) {
+				console.log("MEMSTAT: goroutines:", msg.Error);
+				return;
+			}
+			document.getElementById("ms-goroutines").innerHTML = goroutinesTpl(msg.Result);
+		};
+
+		// Pause and resume updates
+		var paused = false;
+		document.getElementById("ms-pause").onclick = function () {
+			paused = !paused;
+			ws.send(JSON.stringify({Type: paused ? "pause" : "resume"}));
+			this.innerHTML = paused ? "Resume" : "Pause";
+		};
+
 		// ON CLOSE /memstats-feeds
 		ws.onclose = function () {
 			console.log("MEMSTAT: Disconnected.")
 		}
 	}
 
+	// Adds the token, if any, to the query of a URL of the memstats server.
+	// Browsers can not set the Authorization header on websockets.
+	function withToken(url) {
+		if (!token) {
+			return url;
+		}
+		return url + (url.indexOf("?") < 0 ? "?" : "&") + "token=" + encodeURIComponent(token);
+	}
+
+	// Describes the value of a runtime/metrics metric. Histograms are
+	// summarized by their number of samples and approximate percentiles.
+	function describeMetric(name, value) {
+		var bytes = /:bytes$/.test(name);
+		var format = function (v) {
+			if (typeof v !== "number") {
+				return v;
+			}
+			return bytes ? bytesToSize(v) : +v.toPrecision(4);
+		};
+		if (!value || !value.Counts) {
+			return format(value);
+		}
+		var total = _.reduce(value.Counts, function (sum, n) { return sum + n; }, 0);
+		if (total === 0) {
+			return "no samples";
+		}
+		var percentile = function (p) {
+			var seen = 0;
+			for (var i = 0; i < value.Counts.length; i++) {
+				seen += value.Counts[i];
+				if (seen >= total * p) {
+					return format(value.Buckets[i + 1]);
+				}
+			}
+		};
+		return total + " samples, p50 < " + percentile(0.5) + ", p99 < " + percentile(0.99);
+	}
+
+	// Converts a change in bytes to human-readable form, with its sign.
+	function bytesDelta(bytes) {
+		return (bytes < 0 ? "-" : "+") + bytesToSize(Math.abs(bytes));
+	}
+
+	// Returns the class of a change in bytes.
+	function growth(bytes) {
+		return bytes > 0 ? "grow" : "shrink";
+	}
+
 	// Converts bytes to human-readable form with precision(3)
 	function bytesToSize(bytes) {
 		if(bytes == 0) return '0 byte';
@@ -64,13 +303,40 @@ var tpl = template.Must(template.New("name").Parse(`
 		margin: 5px 0 0 0;
 	}
 
+	div.group div.location {
+		color: #777;
+		font-size: smaller;
+	}
+
 	div.group h4 {
 		margin: 5px 0 0 0;
 	}
 
-	#memprofile {
+	div.group.grower {
+		border-color: #d9534f;
+	}
+
+	span.grow {
+		color: #d9534f;
+	}
+
+	span.shrink {
+		color: #5cb85c;
+	}
+
+	#ms-leaks {
+		color: #d9534f;
+	}
+
+	#memprofile, #heapdiff, #runtime, #contention, #ms-goroutines {
 		clear: left;
 	}
+
+	div.goroutines {
+		margin: 10px 0;
+		padding: 10px;
+		border: 1px solid #dfdfdf;
+	}
 {{end}}
 {{define "main"}}
 <!DOCTYPE html>
@@ -180,8 +446,75 @@ var tpl = template.Must(template.New("name").Parse(`
 			</div>
 		</div>
 
+		<% if (obj.Block || obj.Mutex) { %>
+		<div id="contention">
+			<h2>Contention</h2>
+			<% _.each({Block: obj.Block, Mutex: obj.Mutex}, function(records, name) { %>
+				<h3><%= name %> profile</h3>
+				<table>
+					<tr><th>Count</th><th>Cycles</th><th>Callstack</th></tr>
+					<% _.each(records, function(record) { %>
+						<tr>
+							<td><%= record.Count %></td>
+							<td><%= record.Cycles %></td>
+							<td><%= record.Callstack.join(" < ") %></td>
+						</tr>
+					<% }); %>
+				</table>
+			<% }); %>
+		</div>
+		<% } %>
+
+		<% if (obj.Runtime) { %>
+		<div id="runtime">
+			<h2>Runtime metrics</h2>
+			<table>
+			<% _.each(_.keys(obj.Runtime).sort(), function(name) { %>
+				<tr>
+					<td><%= name %></td>
+					<td><%= describeMetric(name, obj.Runtime[name]) %></td>
+				</tr>
+			<% }); %>
+			</table>
+		</div>
+		<% } %>
+
+		<% if (obj.HeapDiff) { %>
+		<div id="heapdiff">
+			<h2>Changes since <%= new Date(HeapDiff.Since).toLocaleTimeString() %></h2>
+			<div class="cell">
+				In use: <span class="<%= growth(HeapDiff.InUseBytes) %>"><%= bytesDelta(HeapDiff.InUseBytes) %></span>
+				in <%= HeapDiff.InUseObjs %> objects.
+				Allocated: <%= bytesDelta(HeapDiff.AllocBytes) %> in <%= HeapDiff.AllocObjs %> objects.
+			</div>
+			<% _.each(HeapDiff.Records, function(record, i) { %>
+				<% var grower = i < 3 && record.InUseBytes > 0; %>
+				<div class="group<%= grower ? ' grower' : '' %>">
+					<div class="cell">In use: <span class="<%= growth(record.InUseBytes) %>"><%= bytesDelta(record.InUseBytes) %></span></div>
+					<div class="cell">In use objects: <%= record.InUseObjs %></div>
+					<div class="cell">Allocated: <%= bytesDelta(record.AllocBytes) %></div>
+					<div class="cell">Objects: <%= record.AllocObjs %></div>
+					<br />
+					<% _.each(record.Frames, function(frame) { %>
+						<div class="cell">
+							<%= frame.Function %><% if (frame.Inlined) { %> (inlined)<% } %>
+							<div class="location"><%= frame.File %>:<%= frame.Line %></div>
+						</div>
+					<% }); %>
+				</div>
+			<% }); %>
+		</div>
+		<% } %>
+
 		<div id="memprofile">
 			<h2>Mem Profile Records (goroutines: <%= NumGo %>)</h2>
+			<% if (obj.ProfileTotals) { %>
+				<div class="cell">
+					Showing <%= (obj.Profiles || []).length %> of <%= ProfileTotals.Records %> records.
+					In use: <%= bytesToSize(ProfileTotals.InUseBytes) %> in <%= ProfileTotals.InUseObjs %> objects.
+					Allocated: <%= bytesToSize(ProfileTotals.AllocBytes) %> in <%= ProfileTotals.AllocObjs %> objects.
+				</div>
+			<% } %>
 			<% _.each(Profiles, function(profile) { %>
 				<div class="group">
 					<div class="cell">Allocated: <%= profile.AllocBytes %></div>
@@ -192,17 +525,132 @@ var tpl = template.Must(template.New("name").Parse(`
 					<div class="cell">In use objects: <%= profile.InUseObjs %></div>
 					<br />
 					Callstack Size: <%= profile.Callstack.length %>
-					<% _.each(profile.Callstack, function(funcName) { %>
-						<div class="cell">
-							<%= funcName %>
-						</div>
-					<% }); %>
+					<% if (profile.Frames) { %>
+						<% _.each(profile.Frames, function(frame) { %>
+							<div class="cell">
+								<%= frame.Function %><% if (frame.Inlined) { %> (inlined)<% } %>
+								<div class="location"><%= frame.File %>:<%= frame.Line %></div>
+							</div>
+						<% }); %>
+					<% } else { %>
+						<% _.each(profile.Callstack, function(funcName) { %>
+							<div class="cell">
+								<%= funcName %>
+							</div>
+						<% }); %>
+					<% } %>
 				</div>
 			<% }); %>
 		</div>
 
 		</script>
+		<script id="ms-goroutines-template" type="template/text">
+		<h2>Goroutines (<%= Total %>)</h2>
+		<% _.each(Groups, function(group) { %>
+			<div class="goroutines">
+				<h4>
+					<%= group.Count %> goroutines <%= group.State %>
+					<% if (group.MaxWait > 0) { %>
+						for <%= Math.round(group.MinWait / 6e10) %>-<%= Math.round(group.MaxWait / 6e10) %> minutes
+					<% } %>
+					<% if (group.Frames.length > 0) { %>
+						at <%= group.Frames[0].Function %>
+					<% } %>
+				</h4>
+				<% _.each(group.Frames, function(frame) { %>
+					<div class="cell"><%= frame.Function %> <%= frame.File %>:<%= frame.Line %></div>
+				<% }); %>
+				<% if (group.CreatedBy) { %>
+					<div class="cell">created by <%= group.CreatedBy.Function %> <%= group.CreatedBy.File %>:<%= group.CreatedBy.Line %></div>
+				<% } %>
+			</div>
+		<% }); %>
+		</script>
+		<script id="ms-leak-template" type="template/text">
+		<h2>Leak suspected since <%= new Date(Since).toLocaleTimeString() %> (<%= GCs %> GCs)</h2>
+		<% if (obj.HeapAlloc) { %>
+			<div class="cell">Heap: <%= bytesToSize(HeapAlloc.From) %> to <%= bytesToSize(HeapAlloc.To) %></div>
+		<% } %>
+		<% if (obj.NumGo) { %>
+			<div class="cell">Goroutines: <%= NumGo.From %> to <%= NumGo.To %></div>
+		<% } %>
+		<% _.each(obj.Stacks, function(stack) { %>
+			<div class="group">
+				<div class="cell">Growth: <%= bytesDelta(stack.Growth) %></div>
+				<div class="cell">In use: <%= bytesToSize(stack.InUseBytes) %></div>
+				<br />
+				<% _.each(stack.Frames, function(frame) { %>
+					<div class="cell">
+						<%= frame.Function %><% if (frame.Inlined) { %> (inlined)<% } %>
+						<div class="location"><%= frame.File %>:<%= frame.Line %></div>
+					</div>
+				<% }); %>
+			</div>
+		<% }); %>
+		</script>
+		<script id="ms-control-template" type="template/text">
+		<% if (obj.Error) { %>
+			<%= Request %> failed: <%= obj.Error %>
+		<% } else { %>
+			<h4><%= Request %><% if (Result.Previous !== undefined) { %> (was <%= Result.Previous %>)<% } %></h4>
+			<table>
+				<tr><th></th><th>Before</th><th>After</th></tr>
+				<% _.each(["HeapAlloc", "HeapSys", "HeapIdle", "HeapReleased", "Sys", "NextGC"], function(key) { %>
+					<tr>
+						<td><%= key %></td>
+						<td><%= bytesToSize(Result.Before[key]) %></td>
+						<td><%= bytesToSize(Result.After[key]) %></td>
+					</tr>
+				<% }); %>
+				<tr><td>NumGC</td><td><%= Result.Before.NumGC %></td><td><%= Result.After.NumGC %></td></tr>
+			</table>
+		<% } %>
+		</script>
+		<script id="ms-cpu-template" type="template/text">
+		<% if (!Done) { %>
+			Capturing CPU profile: <%= Elapsed %>s of <%= Seconds %>s
+		<% } else if (obj.Error) { %>
+			CPU profile failed: <%= obj.Error %>
+		<% } else { %>
+			<h2>CPU profile (<%= (Total / 1e9).toFixed(2) %>s) <a href="<%= Download %>">download</a></h2>
+			<table>
+				<tr><th>Flat</th><th>Flat %</th><th>Cum</th><th>Cum %</th><th>Function</th></tr>
+				<% _.each(obj.Top, function(fn) { %>
+					<tr>
+						<td><%= (fn.Flat / 1e6).toFixed(0) %>ms</td>
+						<td><%= (100 * fn.Flat / Total).toFixed(1) %>%</td>
+						<td><%= (fn.Cum / 1e6).toFixed(0) %>ms</td>
+						<td><%= (100 * fn.Cum / Total).toFixed(1) %>%</td>
+						<td><%= fn.Function %></td>
+					</tr>
+				<% }); %>
+			</table>
+		<% } %>
+		</script>
+		<button id="ms-pause">Pause</button>
+		<button id="ms-goroutines-button">Dump goroutines</button>
+		<select id="ms-profile-sort">
+			<option value="inuse_bytes">Sort profile by in use bytes</option>
+			<option value="alloc_bytes">Sort profile by allocated bytes</option>
+			<option value="alloc_objects">Sort profile by allocated objects</option>
+		</select>
+		<a id="ms-heap" download="heap.pb.gz">Download heap profile</a>
+		<button id="ms-baseline-button">Set baseline</button>
+		<button id="ms-baseline-clear">Clear baseline</button>
+		<a id="ms-baseline" download="base.pb.gz" style="display: none">Download baseline (pprof -base)</a>
+		<input id="ms-cpu-seconds" type="number" min="1" value="10" />
+		<button id="ms-cpu-button">CPU profile</button>
+		<div id="ms-cpu"></div>
+		<button id="ms-gc-button">Run GC</button>
+		<button id="ms-free-button">Free OS memory</button>
+		<input id="ms-gogc" type="number" value="100" />
+		<button id="ms-gogc-button">Set GOGC</button>
+		<input id="ms-limit" type="number" min="1" placeholder="bytes" />
+		<button id="ms-limit-button">Set memory limit</button>
+		<div id="ms-control"></div>
+		<div id="ms-leaks"></div>
 		<div id="ms-viewer"></div>
+		<div id="ms-goroutines"></div>
 
 		<script>{{template "underscoreJS"}}</script>
 		<script>{{template "mainJS" .}}</script>
@@ -0,0 +1,49 @@
+package memstats
+
+import (
+	"runtime"
+	"sort"
+)
+
+// contentionRecord holds information about a block or mutex profile entry.
+type contentionRecord struct {
+	// Count is the number of blocking events or contended lock acquisitions.
+	Count int64
+	// Cycles is the time spent blocked or waiting, in CPU cycles.
+	Cycles int64
+	// Stack trace
+	Callstack []string
+	Frames    []frame
+}
+
+// contentionProfile reads a whole block or mutex profile using read, which
+// is runtime.BlockProfile or runtime.MutexProfile, and returns its size
+// entries with the most cycles.
+func contentionProfile(size int, read func([]runtime.BlockProfileRecord) (int, bool)) []contentionRecord {
+	n, _ := read(nil)
+	var records []runtime.BlockProfileRecord
+	for {
+		// leave room for records added since the profile was sized
+		records = make([]runtime.BlockProfileRecord, n+50)
+		var ok bool
+		n, ok = read(records)
+		if ok {
+			records = records[:n]
+			break
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Cycles > records[j].Cycles })
+	if len(records) > size {
+		records = records[:size]
+	}
+	prof := make([]contentionRecord, len(records))
+	for i, r := range records {
+		prof[i] = contentionRecord{
+			Count:  r.Count,
+			Cycles: r.Cycles,
+			Frames: symbolize(r.Stack()),
+		}
+		prof[i].Callstack = functionNames(prof[i].Frames)
+	}
+	return prof
+}
@@ -0,0 +1,132 @@
+package memstats
+
+import (
+	"encoding/json"
+	"errors"
+	"runtime"
+	"runtime/debug"
+)
+
+var errControlDisabled = errors.New("control commands are disabled")
+
+// controlStats are the memory statistics reported before and after a control
+// command.
+type controlStats struct {
+	HeapAlloc    uint64
+	HeapSys      uint64
+	HeapIdle     uint64
+	HeapReleased uint64
+	Sys          uint64
+	NextGC       uint64
+	NumGC        uint32
+}
+
+// controlResult is the result of a control command. Previous holds the
+// setting replaced by setgcpercent and setmemorylimit.
+type controlResult struct {
+	Before   controlStats
+	After    controlStats
+	Previous *int64 `json:",omitempty"`
+}
+
+// setGCPercentArgs are the arguments of a setgcpercent request.
+type setGCPercentArgs struct {
+	// Percent is passed to debug.SetGCPercent. A negative value disables
+	// the garbage collector. It is required.
+	Percent *int
+}
+
+// setMemoryLimitArgs are the arguments of a setmemorylimit request.
+type setMemoryLimitArgs struct {
+	// Limit is passed to debug.SetMemoryLimit, in bytes. It is required
+	// and must be positive, math.MaxInt64 removes the limit.
+	Limit *int64
+}
+
+// readControlStats returns the current memory statistics.
+func readControlStats() controlStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return controlStats{
+		HeapAlloc:    m.HeapAlloc,
+		HeapSys:      m.HeapSys,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		Sys:          m.Sys,
+		NextGC:       m.NextGC,
+		NumGC:        m.NumGC,
+	}
+}
+
+// control runs fn if the server has control commands enabled, and returns
+// the statistics from before and after it ran.
+func (c *client) control(fn func() *int64) (interface{}, error) {
+	if !c.s.control {
+		return nil, errControlDisabled
+	}
+	var res controlResult
+	res.Before = readControlStats()
+	res.Previous = fn()
+	res.After = readControlStats()
+	return res, nil
+}
+
+// gc runs a garbage collection.
+func (c *client) gc(req *request) (interface{}, error) {
+	return c.control(func() *int64 {
+		runtime.GC()
+		return nil
+	})
+}
+
+// freeOSMemory runs a garbage collection and returns as much memory as
+// possible to the operating system.
+func (c *client) freeOSMemory(req *request) (interface{}, error) {
+	return c.control(func() *int64 {
+		debug.FreeOSMemory()
+		return nil
+	})
+}
+
+// setGCPercent sets the garbage collection target percentage.
+func (c *client) setGCPercent(req *request) (interface{}, error) {
+	if !c.s.control {
+		return nil, errControlDisabled
+	}
+	var args setGCPercentArgs
+	if len(req.Args) > 0 {
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+	}
+	if args.Percent == nil {
+		return nil, errors.New("missing Percent")
+	}
+	return c.control(func() *int64 {
+		prev := int64(debug.SetGCPercent(*args.Percent))
+		return &prev
+	})
+}
+
+// setMemoryLimit sets the soft memory limit of the runtime.
+func (c *client) setMemoryLimit(req *request) (interface{}, error) {
+	if !c.s.control {
+		return nil, errControlDisabled
+	}
+	var args setMemoryLimitArgs
+	if len(req.Args) > 0 {
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+	}
+	if args.Limit == nil {
+		return nil, errors.New("missing Limit")
+	}
+	if *args.Limit <= 0 {
+		return nil, errors.New("memory limit must be positive")
+	}
+	return c.control(func() *int64 {
+		prev := debug.SetMemoryLimit(*args.Limit)
+		return &prev
+	})
+}
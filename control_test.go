@@ -0,0 +1,51 @@
+package memstats
+
+import (
+	"encoding/json"
+	"math"
+	"runtime/debug"
+	"testing"
+)
+
+func TestControlArgs(t *testing.T) {
+	c := &client{s: &Server{control: true}}
+	gogc := debug.SetGCPercent(100)
+	defer debug.SetGCPercent(gogc)
+	limit := debug.SetMemoryLimit(-1)
+	defer debug.SetMemoryLimit(limit)
+
+	for _, tt := range []struct {
+		typ  string
+		args string
+		ok   bool
+	}{
+		{"setgcpercent", "", false},
+		{"setgcpercent", `{}`, false},
+		{"setgcpercent", `{"Percent":null}`, false},
+		{"setgcpercent", `{"Percent":"100"}`, false},
+		{"setgcpercent", `{"Percent":100}`, true},
+		{"setgcpercent", `{"Percent":-1}`, true},
+		{"setmemorylimit", "", false},
+		{"setmemorylimit", `{}`, false},
+		{"setmemorylimit", `{"Limit":null}`, false},
+		{"setmemorylimit", `{"Limit":0}`, false},
+		{"setmemorylimit", `{"Limit":-1}`, false},
+		{"setmemorylimit", `{"Limit":9223372036854775807}`, true},
+	} {
+		req := &request{Type: tt.typ, Args: json.RawMessage(tt.args)}
+		res, err := commands[tt.typ](c, req)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s %s: got error %v, want ok %v", tt.typ, tt.args, err, tt.ok)
+			continue
+		}
+		if err == nil && res.(controlResult).Previous == nil {
+			t.Errorf("%s %s: no previous setting", tt.typ, tt.args)
+		}
+	}
+	if got := debug.SetGCPercent(gogc); got != -1 {
+		t.Errorf("got GOGC %d, want -1", got)
+	}
+	if got := debug.SetMemoryLimit(limit); got != math.MaxInt64 {
+		t.Errorf("got memory limit %d, want %d", got, int64(math.MaxInt64))
+	}
+}
@@ -0,0 +1,95 @@
+package memstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// maxCPUProfile is the longest CPU profile that clients may capture.
+const maxCPUProfile = 5 * time.Minute
+
+// cpuProfileArgs are the arguments of a cpuprofile request.
+type cpuProfileArgs struct {
+	// Seconds is the duration of the capture. It defaults to 10 seconds.
+	Seconds int
+}
+
+// cpuProfileMessage reports the progress of a CPU profile capture. It is sent
+// every second and once the capture is done.
+type cpuProfileMessage struct {
+	Type    string // always "cpuprofile"
+	ID      string `json:",omitempty"` // ID of the request that started the capture
+	Elapsed int    // seconds
+	Seconds int
+	Done    bool
+	Error   string `json:",omitempty"`
+	// Profile is the ID under which the profile can be downloaded from
+	// /memstats-profile.
+	Profile string `json:",omitempty"`
+	// Total is the CPU time in the profile, in nanoseconds.
+	Total int64 `json:",omitempty"`
+	// Top lists the functions that used the most CPU time.
+	Top []topFunction `json:",omitempty"`
+}
+
+// cpuProfile starts a CPU profile capture. Its progress and result are sent
+// as messages of type "cpuprofile". Only one capture may run at a time.
+func (c *client) cpuProfile(req *request) (interface{}, error) {
+	args := cpuProfileArgs{Seconds: 10}
+	if len(req.Args) > 0 {
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+	}
+	d := time.Duration(args.Seconds) * time.Second
+	if d <= 0 || d > maxCPUProfile {
+		return nil, errors.New("duration must be between 1 second and " + maxCPUProfile.String())
+	}
+	if !atomic.CompareAndSwapInt32(&c.s.cpuBusy, 0, 1) {
+		return nil, errors.New("a CPU profile is already being captured")
+	}
+	buf := new(bytes.Buffer)
+	if err := pprof.StartCPUProfile(buf); err != nil {
+		atomic.StoreInt32(&c.s.cpuBusy, 0)
+		return nil, err
+	}
+	go c.captureCPU(req.ID, d, buf)
+	return args, nil
+}
+
+// captureCPU stops the running CPU profile after d, or when the server shuts
+// down, and stores it. Progress is posted to c while it is connected.
+func (c *client) captureCPU(id string, d time.Duration, buf *bytes.Buffer) {
+	defer atomic.StoreInt32(&c.s.cpuBusy, 0)
+	msg := cpuProfileMessage{Type: "cpuprofile", ID: id, Seconds: int(d / time.Second)}
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	end := time.After(d)
+loop:
+	for {
+		select {
+		case <-t.C:
+			msg.Elapsed++
+			c.post(msg)
+		case <-end:
+			break loop
+		case <-c.s.quit:
+			break loop
+		}
+	}
+	pprof.StopCPUProfile()
+	msg.Done = true
+	msg.Elapsed = msg.Seconds
+	msg.Profile = c.s.profiles.put(buf.Bytes())
+	p, err := parseProfile(buf.Bytes())
+	if err != nil {
+		msg.Error = err.Error()
+	} else {
+		msg.Top, msg.Total = p.topFunctions("cpu/nanoseconds", 20)
+	}
+	c.post(msg)
+}
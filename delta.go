@@ -0,0 +1,258 @@
+package memstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// deltaMessage holds the changes between two samples sent to a client in
+// delta mode. Applying it to the previously reconstructed sample yields the
+// current one.
+type deltaMessage struct {
+	Type string // always "delta"
+	Time interface{}
+	Seq  int
+	// Set maps the dot-separated paths of changed values to their new value.
+	// Array elements are addressed by their index. Arrays whose length
+	// changed are sent whole.
+	Set map[string]interface{} `json:",omitempty"`
+	// Del lists the paths of values that were removed.
+	Del []string `json:",omitempty"`
+	// GCPauses holds the pauses of garbage collections that ran since the
+	// previous sample.
+	GCPauses *pauseDelta `json:",omitempty"`
+	// Profiles holds the memory profile records that changed.
+	Profiles *profileDelta `json:",omitempty"`
+}
+
+// pauseDelta holds new GC pauses, most recent first. They are to be
+// prepended to GCStats.Pause and GCStats.PauseEnd, which are then truncated
+// to Len entries.
+type pauseDelta struct {
+	Pause    []interface{}
+	PauseEnd []interface{}
+	Len      int
+}
+
+// profileDelta holds changed profile records by key. Order lists the keys of
+// all records in order, and is only sent when it changed.
+type profileDelta struct {
+	Set   map[string]interface{} `json:",omitempty"`
+	Del   []string               `json:",omitempty"`
+	Order []string               `json:",omitempty"`
+}
+
+// deltaEncoder encodes the samples sent to one client as a keyframe followed
+// by deltas. A new keyframe is sent every keyframe messages.
+type deltaEncoder struct {
+	keyframe int
+
+	seq      int                    // number of messages sent since the last keyframe
+	values   map[string]interface{} // previous tree, without pauses and profiles
+	numGC    json.Number
+	hasGC    bool
+	profiles map[string]string // key to encoded record
+	order    []string
+}
+
+// reset makes the next message a keyframe.
+func (d *deltaEncoder) reset() { d.values = nil }
+
+// encode returns the message to send for the projected sample tree.
+func (d *deltaEncoder) encode(tree map[string]interface{}) interface{} {
+	values := make(map[string]interface{}, len(tree))
+	for k, v := range tree {
+		if k != "GCStats" && k != "Profiles" {
+			values[k] = v
+		}
+	}
+	gc, _ := tree["GCStats"].(map[string]interface{})
+	if gc != nil {
+		stats := make(map[string]interface{}, len(gc))
+		for k, v := range gc {
+			if k != "Pause" && k != "PauseEnd" {
+				stats[k] = v
+			}
+		}
+		values["GCStats"] = stats
+	}
+	records, _ := tree["Profiles"].([]interface{})
+	profiles := make(map[string]string, len(records))
+	order := make([]string, len(records))
+	for i, rec := range records {
+		order[i] = profileKey(rec)
+		b, _ := json.Marshal(rec)
+		profiles[order[i]] = string(b)
+	}
+
+	keyframe := d.values == nil || d.seq >= d.keyframe
+	prevGC, hadGC := d.numGC, d.hasGC
+	if gc != nil {
+		d.numGC, _ = gc["NumGC"].(json.Number)
+	}
+	d.hasGC = gc != nil
+	if keyframe {
+		d.seq = 0
+		d.values, d.profiles, d.order = values, profiles, order
+		out := make(map[string]interface{}, len(tree)+3)
+		for k, v := range tree {
+			out[k] = v
+		}
+		out["Keyframe"] = true
+		out["Seq"] = d.seq
+		if records != nil {
+			out["ProfileKeys"] = order
+		}
+		return out
+	}
+
+	d.seq++
+	msg := deltaMessage{Type: "delta", Time: tree["Time"], Seq: d.seq}
+	msg.diff("", d.values, values)
+	sort.Strings(msg.Del)
+	if gc != nil && hadGC {
+		msg.GCPauses = newPauses(gc, prevGC)
+	}
+	msg.Profiles = diffProfiles(d.profiles, profiles, d.order, order, records)
+	d.values, d.profiles, d.order = values, profiles, order
+	return msg
+}
+
+// newPauses returns the pauses of the GCs that ran since the one numbered
+// prev, or nil if there were none or gc does not hold NumGC, as when the
+// client's fields leave it out.
+func newPauses(gc map[string]interface{}, prev json.Number) *pauseDelta {
+	num, ok := gc["NumGC"].(json.Number)
+	if !ok {
+		return nil
+	}
+	cur, err := num.Int64()
+	if err != nil {
+		return nil
+	}
+	old, err := prev.Int64()
+	if err != nil || cur == old {
+		return nil
+	}
+	pause, _ := gc["Pause"].([]interface{})
+	end, _ := gc["PauseEnd"].([]interface{})
+	n := int(cur - old)
+	if n > len(pause) {
+		n = len(pause)
+	}
+	if n > len(end) {
+		n = len(end)
+	}
+	return &pauseDelta{Pause: pause[:n], PauseEnd: end[:n], Len: len(pause)}
+}
+
+// diffProfiles returns the profile records that changed between two samples,
+// or nil if none did.
+func diffProfiles(prev, cur map[string]string, prevOrder, order []string, records []interface{}) *profileDelta {
+	var pd profileDelta
+	for i, k := range order {
+		if old, ok := prev[k]; !ok || old != cur[k] {
+			if pd.Set == nil {
+				pd.Set = make(map[string]interface{})
+			}
+			pd.Set[k] = records[i]
+		}
+	}
+	for k := range prev {
+		if _, ok := cur[k]; !ok {
+			pd.Del = append(pd.Del, k)
+		}
+	}
+	sort.Strings(pd.Del)
+	if !equalStrings(prevOrder, order) {
+		pd.Order = order
+	}
+	if pd.Set == nil && pd.Del == nil && pd.Order == nil {
+		return nil
+	}
+	return &pd
+}
+
+// diff adds the changes from prev to cur, found under path, to msg. Objects
+// are compared key by key, and arrays of the same length element by element.
+// Other values that changed, including arrays whose length changed, are set
+// whole, so that no stale elements or keys are left behind.
+func (msg *deltaMessage) diff(path string, prev, cur interface{}) {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+	switch c := cur.(type) {
+	case map[string]interface{}:
+		if p, ok := prev.(map[string]interface{}); ok {
+			for k, v := range c {
+				if old, ok := p[k]; ok {
+					msg.diff(join(k), old, v)
+				} else {
+					msg.set(join(k), v)
+				}
+			}
+			for k := range p {
+				if _, ok := c[k]; !ok {
+					msg.Del = append(msg.Del, join(k))
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if p, ok := prev.([]interface{}); ok && len(p) == len(c) {
+			for i := range c {
+				msg.diff(join(strconv.Itoa(i)), p[i], c[i])
+			}
+			return
+		}
+	default:
+		switch prev.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			if prev == cur {
+				return
+			}
+		}
+	}
+	msg.set(path, cur)
+}
+
+// set sets the value at path.
+func (msg *deltaMessage) set(path string, v interface{}) {
+	if msg.Set == nil {
+		msg.Set = make(map[string]interface{})
+	}
+	msg.Set[path] = v
+}
+
+// profileKey returns a key identifying an encoded profile record by the
+// program counters of its call stack. Distinct call sites on the same line
+// have distinct program counters, so their records get distinct keys.
+func profileKey(rec interface{}) string {
+	h := fnv.New64a()
+	if m, ok := rec.(map[string]interface{}); ok {
+		pcs, _ := m["Stack0"].([]interface{})
+		for _, pc := range pcs {
+			fmt.Fprintf(h, "%v\n", pc)
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package memstats
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeSample(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var tree map[string]interface{}
+	if err := decodeTree([]byte(raw), &tree); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestDeltaFields(t *testing.T) {
+	samples := []string{
+		`{"Type":"sample","Time":"t1","GCStats":{"NumGC":3,"PauseTotal":100,"Pause":[10,20,70],"PauseEnd":[1,2,3]}}`,
+		`{"Type":"sample","Time":"t2","GCStats":{"NumGC":4,"PauseTotal":130,"Pause":[30,10,20,70],"PauseEnd":[4,1,2,3]}}`,
+	}
+	d := deltaEncoder{keyframe: 30}
+	var msgs []interface{}
+	for _, raw := range samples {
+		out := project(decodeSample(t, raw), topicAll, []string{"GCStats.PauseTotal"})
+		msgs = append(msgs, d.encode(out))
+	}
+	key, ok := msgs[0].(map[string]interface{})
+	if !ok || key["Keyframe"] != true {
+		t.Fatalf("first message is not a keyframe: %#v", msgs[0])
+	}
+	msg, ok := msgs[1].(deltaMessage)
+	if !ok {
+		t.Fatalf("second message is not a delta: %#v", msgs[1])
+	}
+	if got := msg.Set["GCStats.PauseTotal"]; got != json.Number("130") {
+		t.Errorf("GCStats.PauseTotal = %v, want 130", got)
+	}
+	if msg.GCPauses != nil {
+		t.Errorf("GCPauses = %+v, want none without NumGC", msg.GCPauses)
+	}
+}
+
+func TestDeltaPauses(t *testing.T) {
+	samples := []string{
+		`{"Type":"sample","Time":"t1","GCStats":{"NumGC":3,"Pause":[10,20,70],"PauseEnd":[1,2,3]}}`,
+		`{"Type":"sample","Time":"t2","GCStats":{"NumGC":5,"Pause":[40,30,10,20,70],"PauseEnd":[5,4,1,2,3]}}`,
+	}
+	d := deltaEncoder{keyframe: 30}
+	var msg interface{}
+	for _, raw := range samples {
+		msg = d.encode(project(decodeSample(t, raw), topicAll, nil))
+	}
+	pd := msg.(deltaMessage).GCPauses
+	if pd == nil || len(pd.Pause) != 2 || len(pd.PauseEnd) != 2 || pd.Len != 5 {
+		t.Fatalf("GCPauses = %+v, want the 2 new pauses of 5", pd)
+	}
+}
+
+func TestProfileKey(t *testing.T) {
+	// Both records were allocated on the same line, by different calls.
+	a := decodeSample(t, `{"Stack0":[4198400,4198912,0],"Frames":[{"Function":"main.f","File":"f.go","Line":7}]}`)
+	b := decodeSample(t, `{"Stack0":[4198416,4198912,0],"Frames":[{"Function":"main.f","File":"f.go","Line":7}]}`)
+	if profileKey(a) == profileKey(b) {
+		t.Errorf("records with different program counters have the same key %q", profileKey(a))
+	}
+	if profileKey(a) != profileKey(decodeSample(t, `{"Stack0":[4198400,4198912,0]}`)) {
+		t.Error("records with the same program counters have different keys")
+	}
+}
+
+func TestDeltaArrays(t *testing.T) {
+	samples := []string{
+		`{"Type":"sample","Time":"t1","Block":[{"Count":1,"Callstack":["a","b","c"]},{"Count":2,"Callstack":["d"]}]}`,
+		`{"Type":"sample","Time":"t2","Block":[{"Count":1,"Callstack":["x"]},{"Count":3,"Callstack":["d"]}],"HeapDiff":{"InUseBytes":5}}`,
+		`{"Type":"sample","Time":"t3","Block":[{"Count":1,"Callstack":["x"]}]}`,
+	}
+	d := deltaEncoder{keyframe: 30}
+	var msgs []deltaMessage
+	for i, raw := range samples {
+		msg := d.encode(project(decodeSample(t, raw), topicAll, nil))
+		if i > 0 {
+			msgs = append(msgs, msg.(deltaMessage))
+		}
+	}
+	want := []deltaMessage{
+		{
+			Set: map[string]interface{}{
+				"Time":              "t2",
+				"Block.0.Callstack": []interface{}{"x"},
+				"Block.1.Count":     json.Number("3"),
+				"HeapDiff":          map[string]interface{}{"InUseBytes": json.Number("5")},
+			},
+		},
+		{
+			Set: map[string]interface{}{
+				"Time":  "t3",
+				"Block": []interface{}{map[string]interface{}{"Count": json.Number("1"), "Callstack": []interface{}{"x"}}},
+			},
+			Del: []string{"HeapDiff"},
+		},
+	}
+	for i := range want {
+		if !reflect.DeepEqual(msgs[i].Set, want[i].Set) || !reflect.DeepEqual(msgs[i].Del, want[i].Del) {
+			t.Errorf("delta %d sets %v and deletes %v, want %v and %v", i+1, msgs[i].Set, msgs[i].Del, want[i].Set, want[i].Del)
+		}
+	}
+}
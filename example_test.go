@@ -1,6 +1,12 @@
 package memstats_test
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/gbbr/memstats"
@@ -12,14 +18,192 @@ func ExampleTick() {
 	go memstats.Serve(memstats.Tick(time.Minute))
 }
 
+func ExampleMaxTick() {
+	// Refresh every second, but let clients slow
+	// their own updates down to once every 5 minutes.
+	go memstats.Serve(memstats.Tick(time.Second), memstats.MaxTick(5*time.Minute))
+}
+
+func ExampleKeyframeInterval() {
+	// Send clients in delta mode a full sample
+	// every 10 messages.
+	go memstats.Serve(memstats.KeyframeInterval(10))
+}
+
+func ExampleHistoryAge() {
+	// Keep the samples of the last hour, up to 32MB,
+	// and send them to clients as they connect.
+	go memstats.Serve(memstats.HistoryAge(time.Hour), memstats.HistoryBudget(32<<20))
+}
+
+func ExamplePrometheus() {
+	// Serve Prometheus metrics at http://localhost:6061/metrics.
+	go memstats.Serve(memstats.Prometheus())
+}
+
+func ExampleRuntimeMetrics() {
+	// Read memory statistics without stopping the world.
+	go memstats.Serve(memstats.RuntimeMetrics())
+}
+
+func ExampleBlockProfileRate() {
+	// Record every blocking event and one in five
+	// contended mutexes, and publish both profiles.
+	go memstats.Serve(memstats.BlockProfileRate(1), memstats.MutexProfileFraction(5))
+}
+
+func ExampleLeakWindow() {
+	// Warn clients when memory or goroutines grow after
+	// every garbage collection for 10 minutes, as long as
+	// at least 6 collections ran.
+	go memstats.Serve(memstats.LeakWindow(10*time.Minute), memstats.LeakMinGCs(6))
+}
+
+func ExampleAlerts() {
+	// Alert when the heap stays above 1GB for 5 ticks, and
+	// only resolve once it is back under 900MB.
+	heap := memstats.HeapAllocAbove(1<<30, 5)
+	heap.Resolve = 900 << 20
+	go memstats.Serve(
+		memstats.Alerts(
+			heap,
+			memstats.GCPauseP99Above(10*time.Millisecond),
+			memstats.GoroutineGrowth(50),
+		),
+		memstats.AlertWebhook("http://alerts.example.com/memstats"),
+		memstats.AlertHandler(func(a memstats.Alert) {
+			log.Printf("%s: %s (%g)", a.State, a.Rule, a.Value)
+		}),
+	)
+}
+
+func ExampleControl() {
+	// Let clients run the garbage collector and change
+	// GOGC and the memory limit, on a staging server.
+	go memstats.Serve(memstats.Control(), memstats.ListenAddr("localhost:6061"))
+}
+
+func ExampleTokens() {
+	// Require a token, accepting the previous one while
+	// clients are being moved to the new one. The viewer
+	// is run on a loopback address using:
+	// memstats -token "$MEMSTATS_TOKEN" -http localhost:8080
+	go memstats.Serve(memstats.Tokens(os.Getenv("MEMSTATS_TOKEN"), os.Getenv("MEMSTATS_OLD_TOKEN")))
+}
+
+func ExampleTLS() {
+	// Serve over TLS, only to clients with a certificate
+	// signed by our CA. The viewer is run using:
+	// memstats -tls -sock host:6061
+	go memstats.Serve(
+		memstats.TLS("/etc/memstats/cert.pem", "/etc/memstats/key.pem"),
+		memstats.ClientCAs("/etc/memstats/ca.pem"),
+	)
+}
+
+func ExampleAllowOrigins() {
+	// Allow the viewer hosted on the team's dashboard
+	// to connect, in addition to local pages.
+	go memstats.Serve(memstats.AllowOrigins("https://dashboard.example.com"))
+}
+
+func ExampleCredentials() {
+	// Let the on-call team run the garbage collector, and
+	// only let the service owner change its settings. All
+	// control actions are logged.
+	go memstats.Serve(
+		memstats.Control(),
+		memstats.Credentials(
+			memstats.Credential{Name: "dashboard", Token: os.Getenv("VIEWER_TOKEN"), Role: memstats.RoleViewer},
+			memstats.Credential{Name: "oncall", Token: os.Getenv("ONCALL_TOKEN"), Role: memstats.RoleOperator},
+			memstats.Credential{Name: "owner", Token: os.Getenv("OWNER_TOKEN"), Role: memstats.RoleAdmin},
+		),
+		memstats.AuditFile("/var/log/memstats-audit.jsonl"),
+	)
+}
+
+func ExampleProfileSort() {
+	// Send the 20 call stacks that allocated the most
+	// bytes, including those with no memory in use.
+	go memstats.Serve(
+		memstats.ProfileSort(memstats.SortAllocBytes),
+		memstats.ProfileZero(),
+		memstats.MemRecordSize(20),
+	)
+}
+
 func ExampleListenAddr() {
 	// Start a goroutine that runs the memstat
 	// server at the passed in address.
 	go memstats.Serve(memstats.ListenAddr(":7777"))
 }
 
+func ExampleSocketMode() {
+	// Listen on a unix socket that only the process's
+	// group can connect to. The viewer is run using:
+	// memstats -sock unix:/run/myapp/memstats.sock
+	go memstats.Serve(
+		memstats.ListenAddr("unix:/run/myapp/memstats.sock"),
+		memstats.SocketMode(0660),
+	)
+}
+
+func ExampleListener() {
+	// Serve on a listener opened by the application.
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go memstats.Serve(memstats.Listener(ln))
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/memstats-goroutines")
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.Status)
+	// Output: 200 OK
+}
+
+func ExampleSystemd() {
+	// Accept connections on the socket of a memstats.socket
+	// unit holding FileDescriptorName=memstats, falling back
+	// to :6061 when not socket activated.
+	go memstats.Serve(memstats.Systemd("memstats"))
+}
+
 func ExampleServe() {
 	// Place this line at the top of your application to
 	// start a live web visualization of memory profiling.
 	go memstats.Serve()
 }
+
+func ExampleNewServer() {
+	// Start a server on a free port and shut it down,
+	// closing all live feeds, once it is no longer needed.
+	srv := memstats.NewServer(memstats.ListenAddr("localhost:0"))
+	if err := srv.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	resp, err := http.Get("http://" + srv.Addr().String() + "/memstats-goroutines")
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fmt.Println(srv.Shutdown(ctx))
+	// Output:
+	// 200 OK
+	// <nil>
+}
+
+func ExampleServer_ServeHTTP() {
+	// Mount the feed on an existing mux under /debug/memstats/. To view
+	// it, run: memstats -sock localhost:8000 -prefix /debug/memstats
+	mux := http.NewServeMux()
+	mux.Handle("/debug/memstats/", http.StripPrefix("/debug/memstats", memstats.NewServer()))
+	log.Fatal(http.ListenAndServe("localhost:8000", mux))
+}
@@ -0,0 +1,211 @@
+package memstats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// goroutine is a goroutine parsed from a stack dump.
+type goroutine struct {
+	ID    int64
+	State string
+	// Wait is how long the goroutine has been blocked. The runtime only
+	// reports it in minutes, once it is at least a minute.
+	Wait      time.Duration
+	Locked    bool // locked to its thread
+	Frames    []frame
+	CreatedBy *frame `json:",omitempty"`
+}
+
+// goroutineGroup holds goroutines in the same state with identical stacks.
+type goroutineGroup struct {
+	Count     int
+	State     string
+	MinWait   time.Duration
+	MaxWait   time.Duration
+	IDs       []int64
+	Frames    []frame
+	CreatedBy *frame `json:",omitempty"`
+}
+
+// goroutineDump holds all goroutines, grouped by identical stacks. Groups
+// are sorted by size, largest first.
+type goroutineDump struct {
+	Time   time.Time
+	Total  int
+	Groups []*goroutineGroup
+}
+
+// dumpGoroutines returns a dump of all goroutines.
+func dumpGoroutines() *goroutineDump {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	gs := parseGoroutines(buf)
+	return &goroutineDump{
+		Time:   time.Now(),
+		Total:  len(gs),
+		Groups: groupGoroutines(gs),
+	}
+}
+
+// parseGoroutines parses the output of runtime.Stack.
+func parseGoroutines(buf []byte) []*goroutine {
+	var (
+		gs   []*goroutine
+		g    *goroutine
+		fn   string // function of the frame whose location is expected next
+		crby bool   // whether fn is the creator of g
+	)
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	sc.Buffer(make([]byte, 0, 64<<10), len(buf)+1)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			g = nil
+		case strings.HasPrefix(line, "goroutine "):
+			g = parseGoroutineHeader(line)
+			if g != nil {
+				gs = append(gs, g)
+			}
+			fn = ""
+		case g == nil:
+		case strings.HasPrefix(line, "\t"):
+			if fn == "" {
+				continue
+			}
+			f := parseLocation(fn, line)
+			if crby {
+				g.CreatedBy = &f
+			} else {
+				g.Frames = append(g.Frames, f)
+			}
+			fn = ""
+		case strings.HasPrefix(line, "created by "):
+			fn = strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(fn, " in goroutine "); i >= 0 {
+				fn = fn[:i]
+			}
+			crby = true
+		default:
+			// "...additional frames elided..." has no location and is
+			// dropped as no tab-prefixed line follows it.
+			fn = line
+			if i := strings.LastIndexByte(fn, '('); i > 0 && fn[i:] != "(...)" {
+				fn = fn[:i]
+			}
+			crby = false
+		}
+	}
+	return gs
+}
+
+// parseGoroutineHeader parses a line such as
+// "goroutine 7 [chan receive, 5 minutes, locked to thread]:".
+func parseGoroutineHeader(line string) *goroutine {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "goroutine "), ":")
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return nil
+	}
+	id, err := strconv.ParseInt(line[:i], 10, 64)
+	if err != nil {
+		return nil
+	}
+	g := goroutine{ID: id}
+	attrs := strings.Split(strings.Trim(line[i+1:], "[]"), ", ")
+	g.State = attrs[0]
+	for _, a := range attrs[1:] {
+		switch {
+		case a == "locked to thread":
+			g.Locked = true
+		case strings.HasSuffix(a, " minutes"):
+			if m, err := strconv.Atoi(strings.TrimSuffix(a, " minutes")); err == nil {
+				g.Wait = time.Duration(m) * time.Minute
+			}
+		}
+	}
+	return &g
+}
+
+// parseLocation parses a line such as "\t/src/file.go:12 +0x1d" into the
+// location of a call to fn. Calls to fn which end in "(...)" were inlined.
+func parseLocation(fn, line string) frame {
+	f := frame{Function: strings.TrimSuffix(fn, "(...)")}
+	f.Inlined = f.Function != fn
+	loc := strings.TrimSpace(line)
+	if i := strings.LastIndex(loc, " +0x"); i >= 0 {
+		loc = loc[:i]
+	}
+	if i := strings.LastIndexByte(loc, ':'); i >= 0 {
+		if n, err := strconv.Atoi(loc[i+1:]); err == nil {
+			f.Line = n
+			loc = loc[:i]
+		}
+	}
+	f.File = loc
+	return f
+}
+
+// groupGoroutines groups goroutines that are in the same state and have
+// identical stacks.
+func groupGoroutines(gs []*goroutine) []*goroutineGroup {
+	groups := make(map[string]*goroutineGroup)
+	var order []*goroutineGroup
+	for _, g := range gs {
+		var key strings.Builder
+		key.WriteString(g.State)
+		for _, f := range g.Frames {
+			key.WriteString("\n" + f.Function + " " + f.File + ":" + strconv.Itoa(f.Line))
+		}
+		if g.CreatedBy != nil {
+			key.WriteString("\ncreated by " + g.CreatedBy.Function + " " + g.CreatedBy.File + ":" + strconv.Itoa(g.CreatedBy.Line))
+		}
+		gg, ok := groups[key.String()]
+		if !ok {
+			gg = &goroutineGroup{
+				State:     g.State,
+				MinWait:   g.Wait,
+				Frames:    g.Frames,
+				CreatedBy: g.CreatedBy,
+			}
+			groups[key.String()] = gg
+			order = append(order, gg)
+		}
+		gg.Count++
+		gg.IDs = append(gg.IDs, g.ID)
+		if g.Wait < gg.MinWait {
+			gg.MinWait = g.Wait
+		}
+		if g.Wait > gg.MaxWait {
+			gg.MaxWait = g.Wait
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Count > order[j].Count })
+	return order
+}
+
+// goroutines replies with a dump of all goroutines.
+func (c *client) goroutines(req *request) (interface{}, error) {
+	return dumpGoroutines(), nil
+}
+
+// serveGoroutines writes a dump of all goroutines as JSON.
+func (s *Server) serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dumpGoroutines())
+}
@@ -0,0 +1,164 @@
+package memstats
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseGoroutines(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		dump string
+		want []*goroutine
+	}{
+		{
+			name: "running",
+			dump: `goroutine 1 [running]:
+main.main()
+	/src/main.go:12 +0x1d
+`,
+			want: []*goroutine{{
+				ID:     1,
+				State:  "running",
+				Frames: []frame{{Function: "main.main", File: "/src/main.go", Line: 12}},
+			}},
+		},
+		{
+			name: "wait minutes",
+			dump: `goroutine 7 [chan receive, 5 minutes]:
+main.worker(0xc000010000)
+	/src/worker.go:30 +0x45
+`,
+			want: []*goroutine{{
+				ID:     7,
+				State:  "chan receive",
+				Wait:   5 * time.Minute,
+				Frames: []frame{{Function: "main.worker", File: "/src/worker.go", Line: 30}},
+			}},
+		},
+		{
+			name: "locked to thread",
+			dump: `goroutine 3 [syscall, 12 minutes, locked to thread]:
+syscall.Syscall(0x0, 0x1, 0x2, 0x3)
+	/go/src/syscall/syscall_linux.go:69 +0x25
+`,
+			want: []*goroutine{{
+				ID:     3,
+				State:  "syscall",
+				Wait:   12 * time.Minute,
+				Locked: true,
+				Frames: []frame{{Function: "syscall.Syscall", File: "/go/src/syscall/syscall_linux.go", Line: 69}},
+			}},
+		},
+		{
+			name: "inlined frames",
+			dump: `goroutine 9 [select]:
+main.wait(...)
+	/src/wait.go:8
+main.loop(0xc000020000)
+	/src/loop.go:21 +0x7a
+`,
+			want: []*goroutine{{
+				ID:    9,
+				State: "select",
+				Frames: []frame{
+					{Function: "main.wait", File: "/src/wait.go", Line: 8, Inlined: true},
+					{Function: "main.loop", File: "/src/loop.go", Line: 21},
+				},
+			}},
+		},
+		{
+			name: "created by in goroutine",
+			dump: `goroutine 18 [IO wait]:
+net/http.(*conn).serve(0xc0001a4000, {0x8a1e20, 0xc00019e000})
+	/go/src/net/http/server.go:2009 +0x5f4
+created by net/http.(*Server).Serve in goroutine 1
+	/go/src/net/http/server.go:3086 +0x4db
+`,
+			want: []*goroutine{{
+				ID:        18,
+				State:     "IO wait",
+				Frames:    []frame{{Function: "net/http.(*conn).serve", File: "/go/src/net/http/server.go", Line: 2009}},
+				CreatedBy: &frame{Function: "net/http.(*Server).Serve", File: "/go/src/net/http/server.go", Line: 3086},
+			}},
+		},
+		{
+			name: "elided frames",
+			dump: `goroutine 5 [running]:
+main.recurse(0x64)
+	/src/recurse.go:4 +0x1a
+...additional frames elided...
+created by main.main
+	/src/main.go:9 +0x25
+
+goroutine 6 [sleep]:
+time.Sleep(0x3b9aca00)
+	/go/src/runtime/time.go:195 +0x125
+`,
+			want: []*goroutine{
+				{
+					ID:        5,
+					State:     "running",
+					Frames:    []frame{{Function: "main.recurse", File: "/src/recurse.go", Line: 4}},
+					CreatedBy: &frame{Function: "main.main", File: "/src/main.go", Line: 9},
+				},
+				{
+					ID:     6,
+					State:  "sleep",
+					Frames: []frame{{Function: "time.Sleep", File: "/go/src/runtime/time.go", Line: 195}},
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseGoroutines([]byte(tt.dump))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", formatGoroutines(got), formatGoroutines(tt.want))
+			}
+		})
+	}
+}
+
+func formatGoroutines(gs []*goroutine) string {
+	var s string
+	for _, g := range gs {
+		s += fmt.Sprintf("%+v", *g)
+		if g.CreatedBy != nil {
+			s += fmt.Sprintf(" created by %+v", *g.CreatedBy)
+		}
+		s += "\n"
+	}
+	return s
+}
+
+func TestGroupGoroutines(t *testing.T) {
+	gs := parseGoroutines([]byte(`goroutine 1 [chan receive, 2 minutes]:
+main.worker()
+	/src/worker.go:30 +0x45
+
+goroutine 2 [chan receive]:
+main.worker()
+	/src/worker.go:30 +0x45
+
+goroutine 3 [running]:
+main.main()
+	/src/main.go:12 +0x1d
+
+goroutine 4 [chan receive, 7 minutes]:
+main.worker()
+	/src/worker.go:30 +0x45
+`))
+	groups := groupGoroutines(gs)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	g := groups[0]
+	if g.Count != 3 || !reflect.DeepEqual(g.IDs, []int64{1, 2, 4}) {
+		t.Errorf("largest group has %d goroutines %v, want 3 goroutines [1 2 4]", g.Count, g.IDs)
+	}
+	if g.MinWait != 0 || g.MaxWait != 7*time.Minute {
+		t.Errorf("waits are %s to %s, want 0s to 7m0s", g.MinWait, g.MaxWait)
+	}
+}
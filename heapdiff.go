@@ -0,0 +1,152 @@
+package memstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"time"
+)
+
+// heapBaseline is a memory profile pinned by a client, which following
+// samples are compared against.
+type heapBaseline struct {
+	time    time.Time
+	profile string // ID of the baseline heap profile in pprof format
+	records map[[32]uintptr]runtime.MemProfileRecord
+}
+
+// heapDiffRecord holds the change of a memory profile entry since the
+// baseline.
+type heapDiffRecord struct {
+	InUseBytes int64
+	InUseObjs  int64
+	AllocBytes int64
+	AllocObjs  int64
+	// Stack trace
+	Callstack []string
+	Frames    []frame
+}
+
+// heapDiff holds the changes of the memory profile since a baseline. Records
+// are sorted by growth of in use bytes, biggest growers first.
+type heapDiff struct {
+	Since time.Time
+	// Profile is the ID of the baseline heap profile, which can be
+	// downloaded and passed to pprof using -base.
+	Profile string
+	Records []heapDiffRecord
+	// Totals over all records.
+	InUseBytes int64
+	InUseObjs  int64
+	AllocBytes int64
+	AllocObjs  int64
+}
+
+// baselineArgs are the arguments of a baseline request.
+type baselineArgs struct {
+	// Clear removes the baseline instead of pinning a new one.
+	Clear bool
+}
+
+// baselineResult is the result of a baseline request.
+type baselineResult struct {
+	Time time.Time
+	// Profile is the ID of the baseline heap profile.
+	Profile string
+}
+
+// baseline pins the current memory profile as c's baseline. Samples that
+// hold the profile topic are then sent with a HeapDiff section holding the
+// changes since the baseline. The baseline heap profile is kept in pprof
+// format for download until the baseline is cleared or replaced, or the
+// client disconnects.
+func (c *client) baseline(req *request) (interface{}, error) {
+	var args baselineArgs
+	if len(req.Args) > 0 {
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+	}
+	if c.delta != nil {
+		c.delta.reset()
+	}
+	if args.Clear {
+		c.setBaseline(nil)
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	records := readMemProfile()
+	b := heapBaseline{
+		time:    time.Now(),
+		profile: c.s.profiles.pin(buf.Bytes()),
+		records: make(map[[32]uintptr]runtime.MemProfileRecord, len(records)),
+	}
+	for _, r := range records {
+		b.records[r.Stack0] = r
+	}
+	c.setBaseline(&b)
+	return baselineResult{Time: b.time, Profile: b.profile}, nil
+}
+
+// setBaseline replaces c's baseline with b, which may be nil, releasing the
+// heap profile of the previous one.
+func (c *client) setBaseline(b *heapBaseline) {
+	if c.base != nil {
+		c.s.profiles.unpin(c.base.profile)
+	}
+	c.base = b
+}
+
+// diff returns the changes from the baseline to records, keeping the size
+// records that grew the most. Unchanged records are left out. Profile
+// records are never removed by the runtime, so every baseline record is
+// still found in records.
+func (b *heapBaseline) diff(records []runtime.MemProfileRecord, size int) *heapDiff {
+	d := heapDiff{Since: b.time, Profile: b.profile}
+	var changed []heapDiffRecord
+	var stacks [][]uintptr
+	for i := range records {
+		r := &records[i]
+		old := b.records[r.Stack0]
+		dr := heapDiffRecord{
+			InUseBytes: r.InUseBytes() - old.InUseBytes(),
+			InUseObjs:  r.InUseObjects() - old.InUseObjects(),
+			AllocBytes: r.AllocBytes - old.AllocBytes,
+			AllocObjs:  r.AllocObjects - old.AllocObjects,
+		}
+		d.InUseBytes += dr.InUseBytes
+		d.InUseObjs += dr.InUseObjs
+		d.AllocBytes += dr.AllocBytes
+		d.AllocObjs += dr.AllocObjs
+		if dr.InUseBytes != 0 || dr.AllocBytes != 0 {
+			changed = append(changed, dr)
+			stacks = append(stacks, r.Stack())
+		}
+	}
+	idx := make([]int, len(changed))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := &changed[idx[i]], &changed[idx[j]]
+		if a.InUseBytes != b.InUseBytes {
+			return a.InUseBytes > b.InUseBytes
+		}
+		return a.AllocBytes > b.AllocBytes
+	})
+	if len(idx) > size {
+		idx = idx[:size]
+	}
+	d.Records = make([]heapDiffRecord, len(idx))
+	for i, j := range idx {
+		d.Records[i] = changed[j]
+		d.Records[i].Frames = symbolize(stacks[j])
+		d.Records[i].Callstack = functionNames(d.Records[i].Frames)
+	}
+	return &d
+}
@@ -0,0 +1,164 @@
+package memstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// historyTopics are the topics kept in the history. Memory profiles are left
+// out because of their size.
+const historyTopics = topicDefault &^ topicProfile
+
+// history is a ring of past samples, bounded by count, age and size. Samples
+// are stored in their encoded form.
+type history struct {
+	size   int
+	age    time.Duration
+	budget int
+
+	mu      sync.Mutex
+	entries []historyEntry // oldest first
+	bytes   int
+}
+
+// historyEntry is an encoded sample taken at Time.
+type historyEntry struct {
+	Time time.Time
+	Raw  json.RawMessage
+}
+
+// add appends the sample tree to the history, evicting the oldest samples
+// until the history is within its bounds again.
+func (h *history) add(t time.Time, tree map[string]interface{}) error {
+	raw, err := json.Marshal(project(tree, historyTopics, nil))
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, historyEntry{Time: t, Raw: raw})
+	h.bytes += len(raw)
+	var n int
+	for n < len(h.entries) && h.full(t, n) {
+		h.bytes -= len(h.entries[n].Raw)
+		n++
+	}
+	if n > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[n:]...)
+	}
+	return nil
+}
+
+// full reports whether the history exceeds one of its bounds while it still
+// holds its n oldest entries.
+func (h *history) full(now time.Time, n int) bool {
+	switch {
+	case h.size > 0 && len(h.entries)-n > h.size:
+		return true
+	case h.age > 0 && now.Sub(h.entries[n].Time) > h.age:
+		return true
+	case h.budget > 0 && h.bytes > h.budget:
+		return true
+	}
+	return false
+}
+
+// between returns the samples taken from from to to, inclusive. Zero times
+// are unbounded.
+func (h *history) between(from, to time.Time) []json.RawMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var raw []json.RawMessage
+	for _, e := range h.entries {
+		if (!from.IsZero() && e.Time.Before(from)) || (!to.IsZero() && e.Time.After(to)) {
+			continue
+		}
+		raw = append(raw, e.Raw)
+	}
+	return raw
+}
+
+// record adds the samples of sub to the server's history until the server
+// shuts down.
+func (s *Server) record(sub *subscription) {
+	defer s.smp.unsubscribe(sub)
+	s.smp.update(sub, func(sub *subscription) { sub.topics = historyTopics })
+	for {
+		select {
+		case smp := <-sub.C:
+			_, tree, err := smp.encode()
+			if err == nil {
+				err = s.hist.add(smp.Time, tree)
+			}
+			if err != nil {
+				s.logf("history: %s", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// historyMessage holds the samples in the server's history. It is sent to
+// clients after their first subscribe request.
+type historyMessage struct {
+	Type    string // always "history"
+	Samples []interface{}
+}
+
+// backfill returns the history of samples as seen through c's projection.
+func (c *client) backfill() (*historyMessage, error) {
+	msg := historyMessage{Type: "history", Samples: []interface{}{}}
+	c.s.smp.mu.Lock()
+	topics := c.sub.topics
+	c.s.smp.mu.Unlock()
+	for _, raw := range c.s.hist.between(time.Time{}, time.Time{}) {
+		var tree map[string]interface{}
+		if err := decodeTree(raw, &tree); err != nil {
+			return nil, err
+		}
+		msg.Samples = append(msg.Samples, project(tree, topics, c.fields))
+	}
+	return &msg, nil
+}
+
+// serveHistory writes the samples in the history as a JSON array. The time
+// range is selected using the "from" and "to" query parameters, which are
+// either RFC 3339 times or milliseconds since the Unix epoch.
+func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		http.Error(w, "history is disabled", http.StatusNotFound)
+		return
+	}
+	from, err := parseTime(r.FormValue("from"))
+	if err != nil {
+		http.Error(w, "bad from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(r.FormValue("to"))
+	if err != nil {
+		http.Error(w, "bad to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples := s.hist.between(from, to)
+	if samples == nil {
+		samples = []json.RawMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+// parseTime parses an RFC 3339 time or a number of milliseconds since the
+// Unix epoch. The empty string yields the zero time.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
@@ -0,0 +1,157 @@
+package memstats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var historyStart = time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// addSamples adds a sample to h for each offset from historyStart, holding
+// the offset's index as NumGo.
+func addSamples(t *testing.T, h *history, offsets ...time.Duration) {
+	t.Helper()
+	for i, d := range offsets {
+		tree := map[string]interface{}{
+			"Type":     "sample",
+			"Time":     historyStart.Add(d),
+			"NumGo":    json.Number(strconv.Itoa(i)),
+			"Profiles": []interface{}{"left out"},
+		}
+		if err := h.add(historyStart.Add(d), tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// numGos returns the NumGo of each sample in raw.
+func numGos(t *testing.T, raw []json.RawMessage) []int {
+	t.Helper()
+	ns := []int{}
+	for _, r := range raw {
+		var smp struct {
+			NumGo    int
+			Profiles []interface{}
+		}
+		if err := json.Unmarshal(r, &smp); err != nil {
+			t.Fatal(err)
+		}
+		if smp.Profiles != nil {
+			t.Errorf("history holds profiles: %s", r)
+		}
+		ns = append(ns, smp.NumGo)
+	}
+	return ns
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHistoryBounds(t *testing.T) {
+	s := time.Second
+	entrySize := func() int {
+		var h history
+		addSamples(t, &h, 0)
+		return h.bytes
+	}()
+	for _, tt := range []struct {
+		name    string
+		h       *history
+		offsets []time.Duration
+		want    []int
+	}{
+		{"size", &history{size: 3}, []time.Duration{0, s, 2 * s, 3 * s, 4 * s}, []int{2, 3, 4}},
+		{"age", &history{age: time.Minute}, []time.Duration{0, 30 * s, 90 * s}, []int{1, 2}},
+		{"age inclusive", &history{age: time.Minute}, []time.Duration{0, 60 * s}, []int{0, 1}},
+		{"budget", &history{budget: 2*entrySize + entrySize/2}, []time.Duration{0, s, 2 * s, 3 * s}, []int{2, 3}},
+		{"unbounded", &history{}, []time.Duration{0, s, 2 * s}, []int{0, 1, 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addSamples(t, tt.h, tt.offsets...)
+			if got := numGos(t, tt.h.between(time.Time{}, time.Time{})); !equalInts(got, tt.want) {
+				t.Errorf("history holds samples %v, want %v", got, tt.want)
+			}
+			var bytes int
+			for _, e := range tt.h.entries {
+				bytes += len(e.Raw)
+			}
+			if tt.h.bytes != bytes {
+				t.Errorf("history counts %d bytes, entries hold %d", tt.h.bytes, bytes)
+			}
+		})
+	}
+}
+
+func TestHistoryBetween(t *testing.T) {
+	var h history
+	addSamples(t, &h, 0, time.Second, 2*time.Second, 3*time.Second)
+	at := func(d time.Duration) time.Time { return historyStart.Add(d) }
+	for _, tt := range []struct {
+		from, to time.Time
+		want     []int
+	}{
+		{time.Time{}, time.Time{}, []int{0, 1, 2, 3}},
+		{at(time.Second), time.Time{}, []int{1, 2, 3}},
+		{time.Time{}, at(2 * time.Second), []int{0, 1, 2}},
+		{at(time.Second), at(2 * time.Second), []int{1, 2}},
+		{at(1500 * time.Millisecond), at(1600 * time.Millisecond), []int{}},
+	} {
+		if got := numGos(t, h.between(tt.from, tt.to)); !equalInts(got, tt.want) {
+			t.Errorf("between %s and %s: got samples %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestServeHistory(t *testing.T) {
+	s := &Server{hist: &history{}}
+	addSamples(t, s.hist, 0, time.Second, 2*time.Second)
+	ms := strconv.FormatInt(historyStart.Add(time.Second).UnixNano()/int64(time.Millisecond), 10)
+	for _, tt := range []struct {
+		query  string
+		status int
+		want   []int
+	}{
+		{"", http.StatusOK, []int{0, 1, 2}},
+		{"?from=" + ms, http.StatusOK, []int{1, 2}},
+		{"?to=" + historyStart.Add(time.Second).Format(time.RFC3339Nano), http.StatusOK, []int{0, 1}},
+		{"?from=" + historyStart.Add(time.Hour).Format(time.RFC3339), http.StatusOK, []int{}},
+		{"?from=yesterday", http.StatusBadRequest, nil},
+		{"?to=12:00", http.StatusBadRequest, nil},
+	} {
+		w := httptest.NewRecorder()
+		s.serveHistory(w, httptest.NewRequest("GET", "/memstats-history"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%q: got status %d, want %d", tt.query, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var raw []json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if got := numGos(t, raw); !equalInts(got, tt.want) {
+			t.Errorf("%q: got samples %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	(&Server{}).serveHistory(w, httptest.NewRequest("GET", "/memstats-history", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d without a history, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,221 @@
+package memstats
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// leakSuspects is the maximum number of call stacks reported as leak suspects.
+const leakSuspects = 10
+
+// leakTopics are the topics that the leak detector samples.
+const leakTopics = topicMemStats | topicGoroutines | topicProfile
+
+// leakPoint holds the values that the leak detector keeps for a garbage
+// collection, read from the first sample taken after it.
+type leakPoint struct {
+	time      time.Time
+	heapAlloc uint64
+	numGo     int
+	stacks    map[[32]uintptr]int64 // in use bytes by call stack
+}
+
+// leakTrend holds the first and last values of a series that grew over the
+// leak detector's window.
+type leakTrend struct {
+	From int64
+	To   int64
+}
+
+// leakStack is a call stack whose in use bytes grew over the leak detector's
+// window.
+type leakStack struct {
+	// Growth is the number of in use bytes added over the window.
+	Growth     int64
+	InUseBytes int64
+	// Stack trace
+	Callstack []string
+	Frames    []frame
+}
+
+// leakMessage reports a leak suspicion. It is pushed to all clients when
+// growth is first detected, and again every window for as long as it lasts.
+type leakMessage struct {
+	Type string // always "leaksuspect"
+	Time time.Time
+	// Since is the time of the first garbage collection in the window.
+	Since time.Time
+	// GCs is the number of garbage collections in the window.
+	GCs int
+	// HeapAlloc and NumGo are set if they grew after every garbage
+	// collection in the window.
+	HeapAlloc *leakTrend `json:",omitempty"`
+	NumGo     *leakTrend `json:",omitempty"`
+	// Stacks are the call stacks whose in use bytes grew after every
+	// garbage collection in the window, largest growth first.
+	Stacks []leakStack `json:",omitempty"`
+}
+
+// leakDetector looks for values that grow monotonically across garbage
+// collections.
+type leakDetector struct {
+	window time.Duration
+	minGCs int
+
+	numGC  uint32
+	points []leakPoint // oldest first
+	sent   time.Time   // when the latest suspicion was pushed
+
+	mu   sync.Mutex
+	last *leakMessage // latest suspicion, if any
+}
+
+// add records smp if it is the first sample taken after a garbage
+// collection, and returns a leak suspicion if the values kept over the
+// window grew monotonically.
+func (d *leakDetector) add(smp *sample) *leakMessage {
+	if smp.MemStats == nil || smp.MemStats.NumGC == d.numGC {
+		return nil
+	}
+	d.numGC = smp.MemStats.NumGC
+	p := leakPoint{
+		time:      smp.Time,
+		heapAlloc: smp.MemStats.HeapAlloc,
+		numGo:     smp.NumGo,
+		stacks:    make(map[[32]uintptr]int64, len(smp.records)),
+	}
+	for _, r := range smp.records {
+		if n := r.InUseBytes(); n > 0 {
+			p.stacks[r.Stack0] = n
+		}
+	}
+	d.points = append(d.points, p)
+	var n int
+	for n < len(d.points) && smp.Time.Sub(d.points[n].time) > d.window {
+		n++
+	}
+	d.points = d.points[n:]
+	if len(d.points) < d.minGCs {
+		return nil
+	}
+
+	msg := leakMessage{
+		Type:  "leaksuspect",
+		Time:  smp.Time,
+		Since: d.points[0].time,
+		GCs:   len(d.points),
+	}
+	msg.HeapAlloc = d.trend(func(p *leakPoint) (int64, bool) { return int64(p.heapAlloc), true })
+	msg.NumGo = d.trend(func(p *leakPoint) (int64, bool) { return int64(p.numGo), true })
+	stacks := make(map[[32]uintptr]*leakTrend)
+	for stk := range p.stacks {
+		stk := stk
+		// A stack that had no memory in use after some of the collections,
+		// such as that of a one-time allocation, is not growing steadily.
+		t := d.trend(func(p *leakPoint) (int64, bool) {
+			n, ok := p.stacks[stk]
+			return n, ok
+		})
+		if t != nil {
+			stacks[stk] = t
+		}
+	}
+	keys := make([][32]uintptr, 0, len(stacks))
+	for stk := range stacks {
+		keys = append(keys, stk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := stacks[keys[i]], stacks[keys[j]]
+		return a.To-a.From > b.To-b.From
+	})
+	if len(keys) > leakSuspects {
+		keys = keys[:leakSuspects]
+	}
+	for _, stk := range keys {
+		t := stacks[stk]
+		r := runtime.MemProfileRecord{Stack0: stk}
+		ls := leakStack{
+			Growth:     t.To - t.From,
+			InUseBytes: t.To,
+			Frames:     symbolize(r.Stack()),
+		}
+		ls.Callstack = functionNames(ls.Frames)
+		msg.Stacks = append(msg.Stacks, ls)
+	}
+	suspect := msg.HeapAlloc != nil || msg.NumGo != nil || msg.Stacks != nil
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !suspect {
+		d.last = nil
+		return nil
+	}
+	first := d.last == nil
+	d.last = &msg
+	if !first && msg.Time.Sub(d.sent) < d.window {
+		return nil
+	}
+	d.sent = msg.Time
+	return &msg
+}
+
+// trend returns the first and last values of the series read from the points
+// by value, if it never decreased and grew overall. It returns nil if value
+// reports that a point holds no value.
+func (d *leakDetector) trend(value func(*leakPoint) (int64, bool)) *leakTrend {
+	prev, ok := value(&d.points[0])
+	if !ok {
+		return nil
+	}
+	t := leakTrend{From: prev}
+	for i := 1; i < len(d.points); i++ {
+		v, ok := value(&d.points[i])
+		if !ok || v < prev {
+			return nil
+		}
+		prev = v
+	}
+	if prev <= t.From {
+		return nil
+	}
+	t.To = prev
+	return &t
+}
+
+// suspicion returns the latest leak suspicion, or nil if values are not
+// growing.
+func (d *leakDetector) suspicion() *leakMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+// detectLeaks feeds the samples of sub to the server's leak detector and
+// pushes its suspicions to all clients until the server shuts down.
+func (s *Server) detectLeaks(sub *subscription) {
+	defer s.smp.unsubscribe(sub)
+	s.smp.update(sub, func(sub *subscription) { sub.topics = leakTopics })
+	for {
+		select {
+		case smp := <-sub.C:
+			if msg := s.leaks.add(smp); msg != nil {
+				s.logf("leak suspected over the last %d garbage collections", msg.GCs)
+				s.publish(msg)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+var errLeaksDisabled = errors.New("leak detection is disabled")
+
+// leaks replies with the latest leak suspicion, if any.
+func (c *client) leaks(req *request) (interface{}, error) {
+	if c.s.leaks == nil {
+		return nil, errLeaksDisabled
+	}
+	return c.s.leaks.suspicion(), nil
+}
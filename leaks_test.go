@@ -0,0 +1,103 @@
+package memstats
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// leakSample returns the first sample taken after garbage collection numGC,
+// at minute numGC, holding a profile record for each stack in stacks.
+func leakSample(numGC uint32, heapAlloc uint64, numGo int, stacks map[[32]uintptr]int64) *sample {
+	smp := &sample{
+		Time:     time.Unix(0, 0).Add(time.Duration(numGC) * time.Minute),
+		MemStats: &runtime.MemStats{NumGC: numGC, HeapAlloc: heapAlloc},
+		NumGo:    numGo,
+	}
+	for stk, n := range stacks {
+		smp.records = append(smp.records, runtime.MemProfileRecord{AllocBytes: n, Stack0: stk})
+	}
+	return smp
+}
+
+func testStack(skip int) [32]uintptr {
+	var stk [32]uintptr
+	runtime.Callers(skip, stk[:])
+	return stk
+}
+
+func TestLeakDetector(t *testing.T) {
+	grow, once := testStack(1), testStack(2)
+	d := leakDetector{window: time.Hour, minGCs: 4}
+	for i, n := range []int64{100, 200, 300} {
+		stacks := map[[32]uintptr]int64{grow: n}
+		if i > 0 {
+			stacks[once] = 500
+		}
+		if msg := d.add(leakSample(uint32(i+1), 1000, 5, stacks)); msg != nil {
+			t.Fatalf("suspicion after %d garbage collections: %+v", i+1, msg)
+		}
+	}
+	// a sample taken before the next garbage collection is left out
+	if msg := d.add(leakSample(3, 1000, 5, nil)); msg != nil || len(d.points) != 3 {
+		t.Fatalf("sample without a new garbage collection was kept")
+	}
+	msg := d.add(leakSample(4, 1000, 5, map[[32]uintptr]int64{grow: 400, once: 500}))
+	if msg == nil {
+		t.Fatal("no suspicion after 4 garbage collections")
+	}
+	if msg.GCs != 4 || msg.HeapAlloc != nil || msg.NumGo != nil {
+		t.Errorf("got %d GCs, HeapAlloc %+v and NumGo %+v, want 4 GCs only", msg.GCs, msg.HeapAlloc, msg.NumGo)
+	}
+	if len(msg.Stacks) != 1 || msg.Stacks[0].Growth != 300 || msg.Stacks[0].InUseBytes != 400 {
+		t.Errorf("got stacks %+v, want one growing by 300 bytes to 400", msg.Stacks)
+	}
+	if d.suspicion() != msg {
+		t.Error("suspicion is not the latest message")
+	}
+
+	// growth that lasts is only pushed again once per window
+	msg = d.add(leakSample(5, 2000, 6, map[[32]uintptr]int64{grow: 500}))
+	if msg != nil {
+		t.Errorf("suspicion pushed again within the window: %+v", msg)
+	}
+	if last := d.suspicion(); last == nil || last.NumGo == nil || *last.NumGo != (leakTrend{From: 5, To: 6}) {
+		t.Errorf("latest suspicion is %+v, want goroutines growing from 5 to 6", last)
+	}
+
+	// points older than the window are dropped, and growth is no longer
+	// reported once values went down
+	if msg := d.add(leakSample(63, 500, 5, map[[32]uintptr]int64{grow: 100})); msg != nil {
+		t.Errorf("suspicion without growth: %+v", msg)
+	}
+	if len(d.points) != 4 {
+		t.Errorf("kept %d points, want the 4 within the window", len(d.points))
+	}
+	if last := d.suspicion(); last != nil {
+		t.Errorf("latest suspicion is %+v, want none", last)
+	}
+}
+
+func TestLeakTrend(t *testing.T) {
+	const missing = -1
+	for _, tt := range []struct {
+		values []int64
+		want   *leakTrend
+	}{
+		{[]int64{1, 2, 3}, &leakTrend{From: 1, To: 3}},
+		{[]int64{1, 1, 2}, &leakTrend{From: 1, To: 2}},
+		{[]int64{2, 2, 2}, nil},
+		{[]int64{1, 3, 2, 4}, nil},
+		{[]int64{missing, 2, 3}, nil},
+		{[]int64{1, missing, 3}, nil},
+	} {
+		d := leakDetector{points: make([]leakPoint, len(tt.values))}
+		for i, v := range tt.values {
+			d.points[i].numGo = int(v)
+		}
+		got := d.trend(func(p *leakPoint) (int64, bool) { return int64(p.numGo), p.numGo != missing })
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("trend of %v is %+v, want %+v", tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package memstats
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+)
+
+// metricsTopics are the topics that the Prometheus endpoint exposes.
+const metricsTopics = topicMemStats | topicGC | topicGoroutines
+
+// memStatsMetric describes a Prometheus metric read from runtime.MemStats.
+type memStatsMetric struct {
+	name  string
+	typ   string
+	help  string
+	value func(*runtime.MemStats) float64
+}
+
+// memStatsMetrics are the runtime.MemStats values exposed to Prometheus. The
+// names match those of the official Go client's runtime collector.
+var memStatsMetrics = []memStatsMetric{
+	{"go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.",
+		func(m *runtime.MemStats) float64 { return float64(m.Alloc) }},
+	{"go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.",
+		func(m *runtime.MemStats) float64 { return float64(m.TotalAlloc) }},
+	{"go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.",
+		func(m *runtime.MemStats) float64 { return float64(m.Sys) }},
+	{"go_memstats_lookups_total", "counter", "Total number of pointer lookups.",
+		func(m *runtime.MemStats) float64 { return float64(m.Lookups) }},
+	{"go_memstats_mallocs_total", "counter", "Total number of mallocs.",
+		func(m *runtime.MemStats) float64 { return float64(m.Mallocs) }},
+	{"go_memstats_frees_total", "counter", "Total number of frees.",
+		func(m *runtime.MemStats) float64 { return float64(m.Frees) }},
+	{"go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.",
+		func(m *runtime.MemStats) float64 { return float64(m.HeapAlloc) }},
+	{"go_memstats_heap_sys_bytes", "gauge", "Number of heap bytes obtained from system.",
+		func(m *runtime.MemStats) float64 { return float64(m.HeapSys) }},
+	{"go_memstats_heap_idle_bytes", "gauge", "Number of heap bytes waiting to be used.",
+		func(m *runtime.MemStats) float64 { return float64(m.HeapIdle) }},
+	{"go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.",
+		func(m *runtime.MemStats) float64 { return float64(m.HeapInuse) }},
+	{"go_memstats_heap_released_bytes", "gauge", "Number of heap bytes released to OS.",
+		func(m *runtime.MemStats) float64 { return float64(m.HeapReleased) }},
+	{"go_memstats_heap_objects", "gauge", "Number of allocated objects.",
+		func(m *runtime.MemStats) float64 { return float64(m.HeapObjects) }},
+	{"go_memstats_stack_inuse_bytes", "gauge", "Number of bytes in use by the stack allocator.",
+		func(m *runtime.MemStats) float64 { return float64(m.StackInuse) }},
+	{"go_memstats_stack_sys_bytes", "gauge", "Number of bytes obtained from system for stack allocator.",
+		func(m *runtime.MemStats) float64 { return float64(m.StackSys) }},
+	{"go_memstats_mspan_inuse_bytes", "gauge", "Number of bytes in use by mspan structures.",
+		func(m *runtime.MemStats) float64 { return float64(m.MSpanInuse) }},
+	{"go_memstats_mspan_sys_bytes", "gauge", "Number of bytes used for mspan structures obtained from system.",
+		func(m *runtime.MemStats) float64 { return float64(m.MSpanSys) }},
+	{"go_memstats_mcache_inuse_bytes", "gauge", "Number of bytes in use by mcache structures.",
+		func(m *runtime.MemStats) float64 { return float64(m.MCacheInuse) }},
+	{"go_memstats_mcache_sys_bytes", "gauge", "Number of bytes used for mcache structures obtained from system.",
+		func(m *runtime.MemStats) float64 { return float64(m.MCacheSys) }},
+	{"go_memstats_buck_hash_sys_bytes", "gauge", "Number of bytes used by the profiling bucket hash table.",
+		func(m *runtime.MemStats) float64 { return float64(m.BuckHashSys) }},
+	{"go_memstats_gc_sys_bytes", "gauge", "Number of bytes used for garbage collection system metadata.",
+		func(m *runtime.MemStats) float64 { return float64(m.GCSys) }},
+	{"go_memstats_other_sys_bytes", "gauge", "Number of bytes used for other system allocations.",
+		func(m *runtime.MemStats) float64 { return float64(m.OtherSys) }},
+	{"go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.",
+		func(m *runtime.MemStats) float64 { return float64(m.NextGC) }},
+	{"go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.",
+		func(m *runtime.MemStats) float64 { return float64(m.LastGC) / 1e9 }},
+	{"go_memstats_gc_cpu_fraction", "gauge", "The fraction of this program's available CPU time used by the GC since the program started.",
+		func(m *runtime.MemStats) float64 { return m.GCCPUFraction }},
+}
+
+// serveMetrics writes the latest sample in the Prometheus text exposition
+// format.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.prometheus {
+		http.Error(w, "metrics are disabled", http.StatusNotFound)
+		return
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, s.smp.snapshot(metricsTopics))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// writeMetrics writes the values of smp to buf in the Prometheus text
+// exposition format.
+func writeMetrics(buf *bytes.Buffer, smp *sample) {
+	header := func(name, typ, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	header("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(buf, "go_goroutines %d\n", smp.NumGo)
+
+	if gc := smp.GCStats; gc != nil {
+		header("go_gc_duration_seconds", "summary", "A summary of the pause duration of garbage collection cycles.")
+		if len(gc.PauseQuantiles) == 5 {
+			for i, q := range []string{"0", "0.25", "0.5", "0.75", "1"} {
+				fmt.Fprintf(buf, "go_gc_duration_seconds{quantile=%q} %s\n", q, formatFloat(gc.PauseQuantiles[i].Seconds()))
+			}
+		}
+		fmt.Fprintf(buf, "go_gc_duration_seconds_sum %s\n", formatFloat(gc.PauseTotal.Seconds()))
+		fmt.Fprintf(buf, "go_gc_duration_seconds_count %d\n", gc.NumGC)
+	}
+
+	m := smp.MemStats
+	if m == nil {
+		return
+	}
+	for _, mm := range memStatsMetrics {
+		header(mm.name, mm.typ, mm.help)
+		fmt.Fprintf(buf, "%s %s\n", mm.name, formatFloat(mm.value(m)))
+	}
+
+	// BySize is exposed as a histogram of allocation sizes. Allocations that
+	// are larger than the largest size class only count towards +Inf. The
+	// sum is TotalAlloc, which counts allocations by their size class.
+	header("go_memstats_alloc_size_bytes", "histogram", "Histogram of allocations by size class.")
+	var cumulative uint64
+	for _, c := range m.BySize {
+		if c.Size == 0 {
+			continue
+		}
+		cumulative += c.Mallocs
+		fmt.Fprintf(buf, "go_memstats_alloc_size_bytes_bucket{le=\"%d\"} %d\n", c.Size, cumulative)
+	}
+	if m.Mallocs > cumulative {
+		cumulative = m.Mallocs
+	}
+	fmt.Fprintf(buf, "go_memstats_alloc_size_bytes_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(buf, "go_memstats_alloc_size_bytes_sum %d\n", m.TotalAlloc)
+	fmt.Fprintf(buf, "go_memstats_alloc_size_bytes_count %d\n", cumulative)
+}
+
+// formatFloat formats f for the exposition format.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
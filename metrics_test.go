@@ -0,0 +1,65 @@
+package memstats
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestWriteMetrics(t *testing.T) {
+	m := &runtime.MemStats{
+		Alloc:         1 << 20,
+		TotalAlloc:    3 << 20,
+		Sys:           8 << 20,
+		Mallocs:       20,
+		Frees:         5,
+		HeapAlloc:     1 << 20,
+		HeapSys:       4 << 20,
+		HeapObjects:   15,
+		NextGC:        4 << 20,
+		LastGC:        uint64(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC).UnixNano()),
+		GCCPUFraction: 0.125,
+	}
+	m.BySize[1].Size, m.BySize[1].Mallocs = 8, 10
+	m.BySize[2].Size, m.BySize[2].Mallocs = 16, 5
+	m.BySize[3].Size, m.BySize[3].Mallocs = 24, 0
+	smp := &sample{
+		NumGo:    7,
+		MemStats: m,
+		GCStats: &debug.GCStats{
+			NumGC:          3,
+			PauseTotal:     600 * time.Microsecond,
+			PauseQuantiles: []time.Duration{100 * time.Microsecond, 100 * time.Microsecond, 200 * time.Microsecond, 300 * time.Microsecond, 300 * time.Microsecond},
+		},
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, smp)
+	const golden = "testdata/metrics.txt"
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.Bytes(), want)
+	}
+}
+
+func TestWriteMetricsGoroutinesOnly(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, &sample{NumGo: 3})
+	want := "# HELP go_goroutines Number of goroutines that currently exist.\n# TYPE go_goroutines gauge\ngo_goroutines 3\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,65 @@
+package memstats
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// handshake checks the Origin of websocket connections. Browsers send the
+// Origin of the page that opens a websocket, so checking it keeps other web
+// pages from reading the feed. Clients which send no Origin are not browsers
+// and are accepted.
+func (s *Server) handshake(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.allowAnyOrigin {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		s.logf("rejected websocket from %s: malformed origin %q", r.RemoteAddr, origin)
+		return fmt.Errorf("malformed origin %q", origin)
+	}
+	if !s.allowedOrigin(u, r) {
+		s.logf("rejected websocket from %s: origin %q not allowed", r.RemoteAddr, origin)
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+// allowedOrigin reports whether origin is in the server's allowlist, is a
+// local address, or is the literal address of the server itself. The Host
+// header of r is not trusted: using DNS rebinding, a web page can make its
+// own host name resolve to the server, and send a matching Host header.
+func (s *Server) allowedOrigin(origin *url.URL, r *http.Request) bool {
+	for _, o := range s.allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin.Scheme+"://"+origin.Host) {
+			return true
+		}
+	}
+	host := strings.ToLower(origin.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	hostport := origin.Host
+	if origin.Port() == "" {
+		port := "80"
+		if origin.Scheme == "https" {
+			port = "443"
+		}
+		hostport = net.JoinHostPort(origin.Hostname(), port)
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && strings.EqualFold(hostport, addr.String()) {
+		return true
+	}
+	h, _, err := net.SplitHostPort(s.listenAddr)
+	return err == nil && h != "" && strings.EqualFold(hostport, s.listenAddr)
+}
@@ -0,0 +1,60 @@
+package memstats
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestHandshakeOrigin(t *testing.T) {
+	s := NewServer(
+		ListenAddr("memstats.internal:6061"),
+		AllowOrigins("https://viewer.example.com"),
+	)
+	local := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 6061}
+	for _, tt := range []struct {
+		host, origin string
+		ok           bool
+	}{
+		{"10.0.0.5:6061", "", true},
+		{"10.0.0.5:6061", "http://localhost:8080", true},
+		{"10.0.0.5:6061", "http://viewer.localhost", true},
+		{"10.0.0.5:6061", "http://127.0.0.1:8080", true},
+		{"10.0.0.5:6061", "http://[::1]:8080", true},
+		{"10.0.0.5:6061", "https://viewer.example.com", true},
+		{"10.0.0.5:6061", "http://viewer.example.com", false},
+		{"10.0.0.5:6061", "https://viewer.example.com.evil.com", false},
+		{"10.0.0.5:6061", "http://evil.com", false},
+		{"10.0.0.5:6061", "null", false},
+		// pages served by the server, from its literal addresses
+		{"10.0.0.5:6061", "http://10.0.0.5:6061", true},
+		{"memstats.internal:6061", "http://memstats.internal:6061", true},
+		{"10.0.0.5:6061", "http://10.0.0.5:8080", false},
+		// DNS rebinding: evil.com resolves to the server, and the browser
+		// sends a matching Host header
+		{"evil.com:6061", "http://evil.com:6061", false},
+	} {
+		r := httptest.NewRequest("GET", "http://"+tt.host+"/memstats-feed", nil)
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		err := s.handshake(&websocket.Config{}, r)
+		if (err == nil) != tt.ok {
+			t.Errorf("origin %q for host %s: got error %v, want allowed %v", tt.origin, tt.host, err, tt.ok)
+		}
+	}
+}
+
+func TestHandshakeAnyOrigin(t *testing.T) {
+	s := NewServer(AllowAnyOrigin())
+	r := httptest.NewRequest("GET", "http://evil.com:6061/memstats-feed", nil)
+	r.Header.Set("Origin", "http://evil.com:6061")
+	if err := s.handshake(&websocket.Config{}, r); err != nil {
+		t.Errorf("origin rejected with AllowAnyOrigin: %v", err)
+	}
+}
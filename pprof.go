@@ -0,0 +1,365 @@
+package memstats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// serveHeapProfile writes the current heap profile as a gzipped pprof
+// protocol buffer, holding the inuse and alloc sample types. Clients which
+// want it to reflect a fresh garbage collection send the "gc" command over
+// the feed first, which needs control commands to be enabled.
+func (s *Server) serveHeapProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="heap.pb.gz"`)
+	if err := pprof.Lookup("heap").WriteTo(w, 0); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, fmt.Sprintf("could not write heap profile: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// storedProfiles is the number of captured profiles kept for download.
+const storedProfiles = 8
+
+// profileStore keeps the most recently captured profiles in pprof format
+// for download. Pinned profiles, such as heap baselines, are kept apart and
+// never evicted until they are unpinned.
+type profileStore struct {
+	mu     sync.Mutex
+	seq    int
+	ids    []string // oldest first
+	data   map[string][]byte
+	pinned map[string][]byte
+}
+
+// put stores data, evicting the oldest profile if the store is full, and
+// returns its ID.
+func (ps *profileStore) put(data []byte) string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.data == nil {
+		ps.data = make(map[string][]byte)
+	}
+	ps.seq++
+	id := strconv.Itoa(ps.seq)
+	ps.ids = append(ps.ids, id)
+	ps.data[id] = data
+	if len(ps.ids) > storedProfiles {
+		delete(ps.data, ps.ids[0])
+		ps.ids = ps.ids[1:]
+	}
+	return id
+}
+
+// pin stores data until it is unpinned and returns its ID.
+func (ps *profileStore) pin(data []byte) string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.pinned == nil {
+		ps.pinned = make(map[string][]byte)
+	}
+	ps.seq++
+	id := strconv.Itoa(ps.seq)
+	ps.pinned[id] = data
+	return id
+}
+
+// unpin removes the pinned profile with the given ID.
+func (ps *profileStore) unpin(id string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.pinned, id)
+}
+
+// get returns the profile with the given ID.
+func (ps *profileStore) get(id string) ([]byte, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if data, ok := ps.pinned[id]; ok {
+		return data, true
+	}
+	data, ok := ps.data[id]
+	return data, ok
+}
+
+// serveProfile writes the captured profile selected by the "id" query
+// parameter as a gzipped pprof protocol buffer.
+func (s *Server) serveProfile(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	data, ok := s.profiles.get(id)
+	if !ok {
+		http.Error(w, "profile not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="profile-%s.pb.gz"`, id))
+	w.Write(data)
+}
+
+// profileData is a decoded pprof profile, holding what is needed to
+// aggregate samples by function.
+type profileData struct {
+	SampleTypes []string // "type/unit"
+	Samples     []profileSample
+	Locations   map[uint64][]uint64 // location ID to function IDs, innermost first
+	Functions   map[uint64]profileFunction
+}
+
+type profileSample struct {
+	Locations []uint64 // leaf first
+	Values    []int64
+}
+
+type profileFunction struct {
+	Name string
+	File string
+}
+
+// parseProfile decodes a gzipped pprof protocol buffer.
+func parseProfile(data []byte) (*profileData, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	p := profileData{
+		Locations: make(map[uint64][]uint64),
+		Functions: make(map[uint64]profileFunction),
+	}
+	var (
+		strs  []string
+		types [][2]int64
+		funcs = make(map[uint64][2]int64) // function ID to name and file string indexes
+	)
+	err = walkProto(raw, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1: // sample_type
+			var vt [2]int64
+			err := walkProto(b, func(field int, v uint64, _ []byte) error {
+				if field == 1 || field == 2 {
+					vt[field-1] = int64(v)
+				}
+				return nil
+			})
+			types = append(types, vt)
+			return err
+		case 2: // sample
+			var s profileSample
+			err := walkProto(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					if b == nil {
+						s.Locations = append(s.Locations, v)
+						return nil
+					}
+					return walkPacked(b, func(v uint64) { s.Locations = append(s.Locations, v) })
+				case 2:
+					if b == nil {
+						s.Values = append(s.Values, int64(v))
+						return nil
+					}
+					return walkPacked(b, func(v uint64) { s.Values = append(s.Values, int64(v)) })
+				}
+				return nil
+			})
+			p.Samples = append(p.Samples, s)
+			return err
+		case 4: // location
+			var (
+				id  uint64
+				fns []uint64
+			)
+			err := walkProto(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					id = v
+				case 4: // line
+					return walkProto(b, func(field int, v uint64, _ []byte) error {
+						if field == 1 {
+							fns = append(fns, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			p.Locations[id] = fns
+			return err
+		case 5: // function
+			var (
+				id uint64
+				fn [2]int64
+			)
+			err := walkProto(b, func(field int, v uint64, _ []byte) error {
+				switch field {
+				case 1:
+					id = v
+				case 2:
+					fn[0] = int64(v)
+				case 4:
+					fn[1] = int64(v)
+				}
+				return nil
+			})
+			funcs[id] = fn
+			return err
+		case 6: // string_table
+			strs = append(strs, string(b))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(strs)) {
+			return ""
+		}
+		return strs[i]
+	}
+	for _, t := range types {
+		p.SampleTypes = append(p.SampleTypes, str(t[0])+"/"+str(t[1]))
+	}
+	for id, fn := range funcs {
+		p.Functions[id] = profileFunction{Name: str(fn[0]), File: str(fn[1])}
+	}
+	return &p, nil
+}
+
+// errBadProto is returned when a protocol buffer can not be decoded.
+var errBadProto = errors.New("malformed protocol buffer")
+
+// walkProto calls fn for every field of the protocol buffer message in b.
+// Varint fields are passed as v, length-delimited fields as b. Fixed-size
+// fields are skipped.
+func walkProto(b []byte, fn func(field int, v uint64, b []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProto
+		}
+		b = b[n:]
+		field, wire := int(key>>3), key&7
+		switch wire {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errBadProto
+			}
+			b = b[n:]
+			if err := fn(field, v, nil); err != nil {
+				return err
+			}
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errBadProto
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errBadProto
+			}
+			if err := fn(field, 0, b[n:n+int(l)]); err != nil {
+				return err
+			}
+			b = b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errBadProto
+			}
+			b = b[4:]
+		default:
+			return errBadProto
+		}
+	}
+	return nil
+}
+
+// walkPacked calls fn for every varint in the packed repeated field b.
+func walkPacked(b []byte, fn func(v uint64)) error {
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProto
+		}
+		fn(v)
+		b = b[n:]
+	}
+	return nil
+}
+
+// topFunction holds the totals of a function in a profile. Flat counts the
+// samples in which the function is the leaf, Cum those in which it appears
+// anywhere in the stack.
+type topFunction struct {
+	Function string
+	File     string
+	Flat     int64
+	Cum      int64
+}
+
+// topFunctions returns the n functions with the highest flat value of the
+// sample type typ, along with the total of that value over all samples.
+func (p *profileData) topFunctions(typ string, n int) (top []topFunction, total int64) {
+	idx := -1
+	for i, t := range p.SampleTypes {
+		if t == typ {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, 0
+	}
+	byID := make(map[uint64]*topFunction)
+	for _, s := range p.Samples {
+		if idx >= len(s.Values) {
+			continue
+		}
+		v := s.Values[idx]
+		total += v
+		seen := make(map[uint64]bool)
+		for i, loc := range s.Locations {
+			for j, id := range p.Locations[loc] {
+				tf, ok := byID[id]
+				if !ok {
+					fn := p.Functions[id]
+					tf = &topFunction{Function: fn.Name, File: fn.File}
+					byID[id] = tf
+				}
+				if i == 0 && j == 0 {
+					tf.Flat += v
+				}
+				if !seen[id] {
+					tf.Cum += v
+					seen[id] = true
+				}
+			}
+		}
+	}
+	for _, tf := range byID {
+		top = append(top, *tf)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Flat != top[j].Flat {
+			return top[i].Flat > top[j].Flat
+		}
+		return top[i].Cum > top[j].Cum
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, total
+}
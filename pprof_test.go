@@ -0,0 +1,187 @@
+package memstats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+var profiledSink [][]byte
+
+//go:noinline
+func allocProfiled() {
+	profiledSink = append(profiledSink, make([]byte, 1<<20))
+}
+
+// heapProfile returns the current heap profile, after allocating from
+// allocProfiled.
+func heapProfile(t *testing.T) []byte {
+	t.Helper()
+	defer func(rate int) { runtime.MemProfileRate = rate }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+	allocProfiled()
+	// the profile reflects the state as of the last completed collection
+	runtime.GC()
+	runtime.GC()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseHeapProfile(t *testing.T) {
+	p, err := parseProfile(heapProfile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"alloc_objects/count", "alloc_space/bytes", "inuse_objects/count", "inuse_space/bytes"}
+	if !reflect.DeepEqual(p.SampleTypes, want) {
+		t.Errorf("sample types are %q, want %q", p.SampleTypes, want)
+	}
+	top, total := p.topFunctions("inuse_space/bytes", len(p.Functions))
+	var found bool
+	for _, tf := range top {
+		if strings.HasSuffix(tf.Function, ".allocProfiled") {
+			found = true
+			if tf.Flat < 1<<20 || tf.Cum < tf.Flat {
+				t.Errorf("allocProfiled has flat %d and cum %d, want at least 1MB flat", tf.Flat, tf.Cum)
+			}
+			if !strings.HasSuffix(tf.File, "pprof_test.go") {
+				t.Errorf("allocProfiled is in %q, want pprof_test.go", tf.File)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("allocProfiled not found in the heap profile")
+	}
+	if total < 1<<20 {
+		t.Errorf("total in use is %d, want at least 1MB", total)
+	}
+}
+
+// protoField appends a length-delimited or, if b is nil, varint field to m.
+func protoField(m []byte, field int, v uint64, b []byte) []byte {
+	if b == nil {
+		m = binary.AppendUvarint(m, uint64(field)<<3)
+		return binary.AppendUvarint(m, v)
+	}
+	m = binary.AppendUvarint(m, uint64(field)<<3|2)
+	m = binary.AppendUvarint(m, uint64(len(b)))
+	return append(m, b...)
+}
+
+// testProfile returns a gzipped profile with one sample type, in which leaf
+// is called by mid, and mid is also called by itself.
+func testProfile(t *testing.T) []byte {
+	var p []byte
+	p = protoField(p, 1, 0, protoField(protoField(nil, 1, 1, nil), 2, 2, nil))
+	sample := func(value uint64, locs ...uint64) {
+		var s, packed []byte
+		for _, l := range locs {
+			packed = binary.AppendUvarint(packed, l)
+		}
+		s = protoField(s, 1, 0, packed)
+		s = protoField(s, 2, value, nil)
+		p = protoField(p, 2, 0, s)
+	}
+	sample(5, 1, 2)
+	sample(3, 2)
+	sample(2, 1, 2, 2)
+	for id := uint64(1); id <= 2; id++ {
+		line := protoField(nil, 1, id, nil)
+		p = protoField(p, 4, 0, protoField(protoField(nil, 1, id, nil), 4, 0, line))
+		fn := protoField(protoField(protoField(nil, 1, id, nil), 2, id+2, nil), 4, id+4, nil)
+		p = protoField(p, 5, 0, fn)
+	}
+	for _, s := range []string{"", "samples", "count", "main.leaf", "main.mid", "leaf.go", "mid.go"} {
+		p = protoField(p, 6, 0, []byte(s))
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(p)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTopFunctions(t *testing.T) {
+	p, err := parseProfile(testProfile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"samples/count"}; !reflect.DeepEqual(p.SampleTypes, want) {
+		t.Errorf("sample types are %q, want %q", p.SampleTypes, want)
+	}
+	top, total := p.topFunctions("samples/count", 10)
+	want := []topFunction{
+		{Function: "main.leaf", File: "leaf.go", Flat: 7, Cum: 7},
+		{Function: "main.mid", File: "mid.go", Flat: 3, Cum: 10},
+	}
+	if !reflect.DeepEqual(top, want) || total != 10 {
+		t.Errorf("got %+v with total %d, want %+v with total 10", top, total, want)
+	}
+	if top, _ := p.topFunctions("samples/count", 1); len(top) != 1 || top[0].Function != "main.leaf" {
+		t.Errorf("top function is %+v, want main.leaf only", top)
+	}
+	if top, total := p.topFunctions("cpu/nanoseconds", 10); top != nil || total != 0 {
+		t.Errorf("got %+v with total %d for a missing sample type, want none", top, total)
+	}
+}
+
+func TestParseTruncatedProfile(t *testing.T) {
+	data := testProfile(t)
+	if _, err := parseProfile(data[:len(data)/2]); err == nil {
+		t.Error("no error for truncated gzip data")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	raw.ReadFrom(zr)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw.Bytes()[:raw.Len()-3])
+	zw.Close()
+	if _, err := parseProfile(buf.Bytes()); err != errBadProto {
+		t.Errorf("got error %v for a truncated protocol buffer, want %v", err, errBadProto)
+	}
+}
+
+func TestBaselineProfilePinned(t *testing.T) {
+	s := &Server{}
+	c := &client{s: s}
+	download := func(id string) int {
+		w := httptest.NewRecorder()
+		s.serveProfile(w, httptest.NewRequest("GET", "/memstats-profile?id="+id, nil))
+		return w.Code
+	}
+
+	res, err := c.baseline(&request{Type: "baseline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.(baselineResult).Profile
+	for i := 0; i <= storedProfiles; i++ {
+		s.profiles.put([]byte("evicting"))
+	}
+	if code := download(id); code != http.StatusOK {
+		t.Fatalf("baseline download after %d captures: got status %d, want %d", storedProfiles+1, code, http.StatusOK)
+	}
+
+	if _, err := c.baseline(&request{Type: "baseline", Args: []byte(`{"Clear":true}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if code := download(id); code != http.StatusNotFound {
+		t.Errorf("cleared baseline download: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
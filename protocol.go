@@ -0,0 +1,447 @@
+package memstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// request is a message sent by a client over the websocket feed. Type selects
+// the command and Args holds its arguments. The optional ID is echoed back
+// in the reply.
+type request struct {
+	Type string
+	ID   string
+	Args json.RawMessage
+}
+
+// reply is sent in response to every request.
+type reply struct {
+	Type    string // always "reply"
+	ID      string `json:",omitempty"`
+	Request string
+	Error   string      `json:",omitempty"`
+	Result  interface{} `json:",omitempty"`
+}
+
+// command handles a request from c, returning its result.
+type command func(c *client, req *request) (interface{}, error)
+
+// commands maps request types to the commands that handle them.
+var commands = map[string]command{
+	"subscribe":  (*client).subscribe,
+	"pause":      (*client).pause,
+	"resume":     (*client).resume,
+	"goroutines": (*client).goroutines,
+	"cpuprofile": (*client).cpuProfile,
+	"baseline":   (*client).baseline,
+	"leaks":      (*client).leaks,
+
+	// control commands, see Control
+	"gc":             (*client).gc,
+	"freeosmemory":   (*client).freeOSMemory,
+	"setgcpercent":   (*client).setGCPercent,
+	"setmemorylimit": (*client).setMemoryLimit,
+}
+
+// client is a websocket connection to the feed. Its methods run on the
+// connection's goroutine, except for post.
+type client struct {
+	s   *Server
+	ws  *websocket.Conn
+	sub *subscription
+	// out carries messages from other goroutines, see post.
+	out chan interface{}
+	// done is closed once the connection is finished.
+	done chan struct{}
+	// id is who opened the connection.
+	id identity
+
+	// fields is the projection applied to samples, if any.
+	fields []string
+	// profile selects the memory profile records sent to the client, if
+	// they differ from those in the samples.
+	profile *profileOptions
+	// base is the memory profile that samples are compared against, if
+	// pinned.
+	base *heapBaseline
+	// delta encodes samples in delta mode, if enabled.
+	delta *deltaEncoder
+	// subscribed is set after the first subscribe request.
+	subscribed bool
+	// queue holds messages to send after the current reply.
+	queue []interface{}
+}
+
+// serve runs the connection until it fails, is closed by the peer or the
+// server shuts down.
+func (c *client) serve() {
+	in := make(chan *request)
+	defer close(c.done)
+	defer c.setBaseline(nil)
+	go func() {
+		defer close(in)
+		for {
+			var req request
+			if err := websocket.JSON.Receive(c.ws, &req); err != nil {
+				switch err.(type) {
+				case *json.SyntaxError, *json.UnmarshalTypeError:
+					continue
+				}
+				return
+			}
+			select {
+			case in <- &req:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	for {
+		var err error
+		select {
+		case smp := <-c.sub.C:
+			err = c.send(smp)
+		case req, ok := <-in:
+			if !ok {
+				return
+			}
+			err = c.handle(req)
+		case msg := <-c.out:
+			err = websocket.JSON.Send(c.ws, msg)
+		case <-c.s.quit:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// post sends msg to the client from another goroutine. It reports whether
+// the message was sent before the connection finished.
+func (c *client) post(msg interface{}) bool {
+	select {
+	case c.out <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// handle runs the command requested by req if c's role allows it, and replies
+// with its result.
+func (c *client) handle(req *request) error {
+	rep := reply{Type: "reply", ID: req.ID, Request: req.Type}
+	role := commandRoles[req.Type]
+	if role == 0 {
+		role = RoleViewer
+	}
+	cmd, ok := commands[req.Type]
+	switch {
+	case !ok:
+		rep.Error = fmt.Sprintf("unknown request type %q", req.Type)
+	case c.id.Role < role:
+		rep.Error = fmt.Sprintf("%s needs the %s role", req.Type, role)
+	case role > RoleViewer && c.s.auditErr != nil:
+		// the request could not be audited
+		rep.Error = fmt.Sprintf("%s is disabled, the audit file could not be opened", req.Type)
+	default:
+		res, err := cmd(c, req)
+		if err != nil {
+			rep.Error = err.Error()
+		}
+		rep.Result = res
+	}
+	if role > RoleViewer || c.id.Role < role {
+		c.s.audit(auditRecord{
+			Time:   time.Now(),
+			User:   c.id.Name,
+			Role:   c.id.Role,
+			Remote: c.ws.Request().RemoteAddr,
+			Action: req.Type,
+			Args:   req.Args,
+			Error:  rep.Error,
+			Result: rep.Result,
+		})
+	}
+	if err := websocket.JSON.Send(c.ws, rep); err != nil {
+		return err
+	}
+	for len(c.queue) > 0 {
+		msg := c.queue[0]
+		c.queue = c.queue[1:]
+		if err := websocket.JSON.Send(c.ws, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends smp to the client, applying its projection and delta encoding.
+func (c *client) send(smp *sample) error {
+	raw, tree, err := smp.encode()
+	if err != nil {
+		return err
+	}
+	c.s.smp.mu.Lock()
+	topics := c.sub.topics
+	c.s.smp.mu.Unlock()
+	if len(c.fields) == 0 && smp.topics&^topics == 0 && c.delta == nil && c.profile == nil && c.base == nil {
+		return websocket.Message.Send(c.ws, string(raw))
+	}
+	if c.profile != nil && smp.records != nil {
+		if tree, err = c.profile.apply(smp, tree); err != nil {
+			return err
+		}
+	}
+	if c.base != nil && smp.records != nil {
+		diff := struct{ HeapDiff *heapDiff }{c.base.diff(smp.records, c.profileOptions().size)}
+		if tree, err = merge(tree, diff); err != nil {
+			return err
+		}
+	}
+	out := project(tree, topics, c.fields)
+	if c.delta != nil {
+		return websocket.JSON.Send(c.ws, c.delta.encode(out))
+	}
+	return websocket.JSON.Send(c.ws, out)
+}
+
+// profileOptions select the records of a memory profile.
+type profileOptions struct {
+	sort string
+	size int
+	zero bool
+}
+
+// apply returns a copy of the sample tree whose memory profile sections hold
+// the records selected by o.
+func (o *profileOptions) apply(smp *sample, tree map[string]interface{}) (map[string]interface{}, error) {
+	var prof struct {
+		Profiles      []memProfileRecord
+		ProfileTotals *profileTotals
+	}
+	prof.Profiles, prof.ProfileTotals = memProfile(smp.records, o.sort, o.size, o.zero)
+	return merge(tree, prof)
+}
+
+// merge returns a copy of the sample tree to which the sections encoded by
+// the fields of v were added, replacing existing ones.
+func merge(tree map[string]interface{}, v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]interface{}
+	if err := decodeTree(raw, &sections); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(tree)+len(sections))
+	for k, v := range tree {
+		out[k] = v
+	}
+	for k, v := range sections {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// subscribeArgs are the arguments of a subscribe request. Zero values keep
+// the current setting.
+type subscribeArgs struct {
+	// Topics are the sections to receive: memstats, gc, profile,
+	// goroutines, runtime and contention. New clients receive all of them
+	// but runtime and contention.
+	Topics []string
+	// Fields is a projection of dot-separated paths, such as
+	// "MemStats.HeapAlloc". When set, only these fields are sent.
+	Fields []string
+	// Interval is the number of milliseconds between two samples. It is
+	// limited by the server's Tick and MaxTick.
+	Interval int64
+	// ProfileSort is the order in which memory profile records are
+	// ranked: inuse_bytes, alloc_bytes or alloc_objects.
+	ProfileSort string
+	// ProfileSize is the number of top memory profile records to receive.
+	// It is limited by the server's MemRecordSize.
+	ProfileSize int
+	// ProfileZero includes memory profile records with no memory in use.
+	ProfileZero *bool
+	// Delta enables or disables delta mode. In delta mode, a keyframe
+	// holding the full sample is followed by messages of type "delta"
+	// which only hold the changes since the previous message. Keyframes
+	// are repeated periodically and after every subscribe request.
+	Delta *bool
+}
+
+// subscribe changes the topics, fields and interval of c's samples. When no
+// topics are given but fields are, the topics are those of the fields. The
+// first subscribe request is followed by the server's history of samples,
+// if it keeps one.
+func (c *client) subscribe(req *request) (interface{}, error) {
+	var args subscribeArgs
+	if len(req.Args) > 0 {
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+	}
+	var topics topic
+	for _, name := range args.Topics {
+		t, ok := topicNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown topic %q", name)
+		}
+		topics |= t
+	}
+	for _, f := range args.Fields {
+		t, ok := sectionTopics[strings.SplitN(f, ".", 2)[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		if len(args.Topics) == 0 {
+			topics |= t
+		}
+	}
+	interval := time.Duration(args.Interval) * time.Millisecond
+	if interval != 0 {
+		interval = c.s.clampTick(interval)
+	}
+	switch args.ProfileSort {
+	case "", SortInUseBytes, SortAllocBytes, SortAllocObjects:
+	default:
+		return nil, fmt.Errorf("unknown profile order %q", args.ProfileSort)
+	}
+	if args.Fields != nil {
+		c.fields = args.Fields
+	}
+	c.setProfileOptions(args)
+	if args.Delta != nil {
+		c.delta = nil
+		if *args.Delta {
+			c.delta = &deltaEncoder{keyframe: c.s.keyframeInterval}
+		}
+	}
+	if c.delta != nil {
+		c.delta.reset()
+	}
+	var res subscribeArgs
+	c.s.smp.update(c.sub, func(sub *subscription) {
+		if topics != 0 {
+			sub.topics = topics
+		}
+		if interval != 0 {
+			sub.interval = interval
+			sub.next = time.Time{}
+		}
+		res.Topics = sub.topics.names()
+		res.Interval = int64(sub.interval / time.Millisecond)
+	})
+	res.Fields = c.fields
+	prof := c.profileOptions()
+	res.ProfileSort, res.ProfileSize, res.ProfileZero = prof.sort, prof.size, &prof.zero
+	delta := c.delta != nil
+	res.Delta = &delta
+	if !c.subscribed && c.s.hist != nil {
+		msg, err := c.backfill()
+		if err != nil {
+			return nil, err
+		}
+		c.queue = append(c.queue, msg)
+	}
+	c.subscribed = true
+	return res, nil
+}
+
+// profileOptions returns the profile options in effect for c.
+func (c *client) profileOptions() profileOptions {
+	if c.profile != nil {
+		return *c.profile
+	}
+	return profileOptions{c.s.profileSort, c.s.memRecordSize, c.s.profileZero}
+}
+
+// setProfileOptions updates the profile options of c from args. They are
+// cleared when they match the server's.
+func (c *client) setProfileOptions(args subscribeArgs) {
+	o := c.profileOptions()
+	if args.ProfileSort != "" {
+		o.sort = args.ProfileSort
+	}
+	if args.ProfileSize > 0 {
+		o.size = args.ProfileSize
+		if o.size > c.s.memRecordSize {
+			o.size = c.s.memRecordSize
+		}
+	}
+	if args.ProfileZero != nil {
+		o.zero = *args.ProfileZero
+	}
+	c.profile = &o
+	if o == (profileOptions{c.s.profileSort, c.s.memRecordSize, c.s.profileZero}) {
+		c.profile = nil
+	}
+}
+
+// pause stops c's samples until resume is requested.
+func (c *client) pause(req *request) (interface{}, error) {
+	c.s.smp.update(c.sub, func(sub *subscription) { sub.paused = true })
+	return nil, nil
+}
+
+// resume restarts c's samples after a pause.
+func (c *client) resume(req *request) (interface{}, error) {
+	c.s.smp.update(c.sub, func(sub *subscription) {
+		sub.paused = false
+		sub.next = time.Time{}
+	})
+	return nil, nil
+}
+
+// project returns the parts of the encoded sample tree that belong to the
+// given topics. If fields is not empty, only those paths are kept.
+func project(tree map[string]interface{}, topics topic, fields []string) map[string]interface{} {
+	out := map[string]interface{}{
+		"Type": tree["Type"],
+		"Time": tree["Time"],
+	}
+	if len(fields) == 0 {
+		for k, t := range sectionTopics {
+			if v, ok := tree[k]; ok && topics&t != 0 {
+				out[k] = v
+			}
+		}
+		return out
+	}
+	for _, f := range fields {
+		path := strings.Split(f, ".")
+		if topics&sectionTopics[path[0]] == 0 {
+			continue
+		}
+		src, dst := tree, out
+		for i, k := range path {
+			v, ok := src[k]
+			if !ok {
+				break
+			}
+			if i == len(path)-1 {
+				dst[k] = v
+				break
+			}
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				break
+			}
+			next, ok := dst[k].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				dst[k] = next
+			}
+			src, dst = m, next
+		}
+	}
+	return out
+}
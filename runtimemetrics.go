@@ -0,0 +1,144 @@
+package memstats
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+)
+
+// runtimeHistogram is a copy of a metrics.Float64Histogram. Buckets holds the
+// boundaries of the buckets, which may include -Inf and +Inf.
+type runtimeHistogram struct {
+	Counts  []uint64
+	Buckets []histogramBound
+}
+
+// histogramBound is a bucket boundary. Infinite boundaries are encoded as the
+// JSON strings "-Inf" and "+Inf".
+type histogramBound float64
+
+// MarshalJSON implements json.Marshaler.
+func (b histogramBound) MarshalJSON() ([]byte, error) {
+	switch f := float64(b); {
+	case math.IsInf(f, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Inf"`), nil
+	default:
+		return strconv.AppendFloat(nil, f, 'g', -1, 64), nil
+	}
+}
+
+// runtimeReader reads all metrics supported by the runtime/metrics package.
+type runtimeReader struct {
+	mu      sync.Mutex
+	samples []metrics.Sample
+}
+
+func newRuntimeReader() *runtimeReader {
+	descs := metrics.All()
+	r := runtimeReader{samples: make([]metrics.Sample, len(descs))}
+	for i, d := range descs {
+		r.samples[i].Name = d.Name
+	}
+	return &r
+}
+
+// read returns the current value of every supported metric by name. Values
+// are uint64, float64 or *runtimeHistogram.
+func (r *runtimeReader) read() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metrics.Read(r.samples)
+	values := make(map[string]interface{}, len(r.samples))
+	for _, s := range r.samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			values[s.Name] = s.Value.Float64()
+		case metrics.KindFloat64Histogram:
+			// the histogram's memory is reused by the next read
+			h := s.Value.Float64Histogram()
+			rh := runtimeHistogram{
+				Counts:  append([]uint64(nil), h.Counts...),
+				Buckets: make([]histogramBound, len(h.Buckets)),
+			}
+			for i, b := range h.Buckets {
+				rh.Buckets[i] = histogramBound(b)
+			}
+			values[s.Name] = &rh
+		}
+	}
+	return values
+}
+
+// memStatsFromRuntime fills m with the runtime.MemStats equivalents of the
+// runtime/metrics values, without stopping the world. Fields which have no
+// equivalent, such as PauseNs, are left empty; LastGC and PauseTotalNs are
+// read using debug.ReadGCStats.
+func memStatsFromRuntime(m *runtime.MemStats, values map[string]interface{}) {
+	u := func(name string) uint64 {
+		v, _ := values[name].(uint64)
+		return v
+	}
+	m.Alloc = u("/memory/classes/heap/objects:bytes")
+	m.TotalAlloc = u("/gc/heap/allocs:bytes")
+	m.Sys = u("/memory/classes/total:bytes")
+	m.Mallocs = u("/gc/heap/allocs:objects") + u("/gc/heap/tiny/allocs:objects")
+	m.Frees = u("/gc/heap/frees:objects") + u("/gc/heap/tiny/allocs:objects")
+	m.HeapAlloc = m.Alloc
+	m.HeapInuse = m.Alloc + u("/memory/classes/heap/unused:bytes")
+	m.HeapReleased = u("/memory/classes/heap/released:bytes")
+	m.HeapIdle = u("/memory/classes/heap/free:bytes") + m.HeapReleased
+	m.HeapSys = m.HeapInuse + m.HeapIdle
+	m.HeapObjects = u("/gc/heap/objects:objects")
+	m.StackInuse = u("/memory/classes/heap/stacks:bytes")
+	m.StackSys = m.StackInuse + u("/memory/classes/os-stacks:bytes")
+	m.MSpanInuse = u("/memory/classes/metadata/mspan/inuse:bytes")
+	m.MSpanSys = m.MSpanInuse + u("/memory/classes/metadata/mspan/free:bytes")
+	m.MCacheInuse = u("/memory/classes/metadata/mcache/inuse:bytes")
+	m.MCacheSys = m.MCacheInuse + u("/memory/classes/metadata/mcache/free:bytes")
+	m.BuckHashSys = u("/memory/classes/profiling/buckets:bytes")
+	m.GCSys = u("/memory/classes/metadata/other:bytes")
+	m.OtherSys = u("/memory/classes/other:bytes")
+	m.NextGC = u("/gc/heap/goal:bytes")
+	m.NumGC = uint32(u("/gc/cycles/total:gc-cycles"))
+	m.NumForcedGC = uint32(u("/gc/cycles/forced:gc-cycles"))
+	m.EnableGC = true
+	gcTotal, _ := values["/cpu/classes/gc/total:cpu-seconds"].(float64)
+	cpuTotal, _ := values["/cpu/classes/total:cpu-seconds"].(float64)
+	if cpuTotal > 0 {
+		m.GCCPUFraction = gcTotal / cpuTotal
+	}
+
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	if !gc.LastGC.IsZero() {
+		m.LastGC = uint64(gc.LastGC.UnixNano())
+	}
+	m.PauseTotalNs = uint64(gc.PauseTotal)
+
+	// The size classes are the upper bounds of the by-size histograms' buckets,
+	// which are one past the size.
+	allocs, _ := values["/gc/heap/allocs-by-size:bytes"].(*runtimeHistogram)
+	frees, _ := values["/gc/heap/frees-by-size:bytes"].(*runtimeHistogram)
+	if allocs == nil {
+		return
+	}
+	for i := 0; i < len(allocs.Counts) && i+1 < len(m.BySize); i++ {
+		upper := float64(allocs.Buckets[i+1])
+		if math.IsInf(upper, 1) {
+			break
+		}
+		c := &m.BySize[i+1]
+		c.Size = uint32(upper) - 1
+		c.Mallocs = allocs.Counts[i]
+		if frees != nil && i < len(frees.Counts) {
+			c.Frees = frees.Counts[i]
+		}
+	}
+}
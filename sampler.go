@@ -0,0 +1,335 @@
+package memstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// subscriptionBuffer is the number of samples buffered for each subscriber.
+// When a subscriber falls behind, its oldest buffered sample is dropped.
+const subscriptionBuffer = 4
+
+// topic is a set of sections that a sample may contain.
+type topic uint
+
+const (
+	topicMemStats topic = 1 << iota
+	topicGC
+	topicProfile
+	topicGoroutines
+	topicRuntime
+	topicContention
+
+	topicAll = topicMemStats | topicGC | topicProfile | topicGoroutines | topicRuntime | topicContention
+
+	// topicDefault are the topics of new subscriptions. The runtime and
+	// contention topics are only sent to clients that ask for them.
+	topicDefault = topicMemStats | topicGC | topicProfile | topicGoroutines
+)
+
+// topicNames maps the topic names used by clients to topics.
+var topicNames = map[string]topic{
+	"memstats":   topicMemStats,
+	"gc":         topicGC,
+	"profile":    topicProfile,
+	"goroutines": topicGoroutines,
+	"runtime":    topicRuntime,
+	"contention": topicContention,
+}
+
+// sectionTopics maps the top-level fields of an encoded sample, including
+// those added for each client such as HeapDiff, to the topic that they
+// belong to.
+var sectionTopics = map[string]topic{
+	"MemStats":      topicMemStats,
+	"GCStats":       topicGC,
+	"Profiles":      topicProfile,
+	"ProfileTotals": topicProfile,
+	"HeapDiff":      topicProfile,
+	"NumGo":         topicGoroutines,
+	"Runtime":       topicRuntime,
+	"Block":         topicContention,
+	"Mutex":         topicContention,
+}
+
+// names returns the names of all topics in t.
+func (t topic) names() []string {
+	var names []string
+	for _, name := range []string{"memstats", "gc", "profile", "goroutines", "runtime", "contention"} {
+		if t&topicNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sample is a snapshot of the runtime's memory statistics. It only holds the
+// sections of its topics. Samples are shared between all subscribers and must
+// not be modified once broadcast.
+type sample struct {
+	Type     string
+	Time     time.Time
+	MemStats *runtime.MemStats  `json:",omitempty"`
+	Profiles []memProfileRecord `json:",omitempty"`
+	// ProfileTotals holds the totals over the whole memory profile.
+	ProfileTotals *profileTotals `json:",omitempty"`
+	GCStats       *debug.GCStats `json:",omitempty"`
+	NumGo         int            `json:",omitempty"`
+	// Runtime maps the names of runtime/metrics metrics to their values.
+	Runtime map[string]interface{} `json:",omitempty"`
+	// Block and Mutex hold the records of the block and mutex profiles.
+	Block []contentionRecord `json:",omitempty"`
+	Mutex []contentionRecord `json:",omitempty"`
+
+	topics topic
+	// records holds the whole memory profile, for clients which rank
+	// records differently than Profiles.
+	records []runtime.MemProfileRecord
+	encOnce sync.Once
+	raw     []byte
+	tree    map[string]interface{}
+	err     error
+}
+
+// encode returns the JSON encoding of smp along with its decoded tree, which
+// can be used to build projections. Both are computed once and shared.
+func (smp *sample) encode() ([]byte, map[string]interface{}, error) {
+	smp.encOnce.Do(func() {
+		smp.raw, smp.err = json.Marshal(smp)
+		if smp.err != nil {
+			return
+		}
+		smp.err = decodeTree(smp.raw, &smp.tree)
+	})
+	return smp.raw, smp.tree, smp.err
+}
+
+// decodeTree decodes the JSON in raw into tree, keeping numbers as
+// json.Number so that large integers do not lose precision.
+func decodeTree(raw []byte, tree *map[string]interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(tree)
+}
+
+// sampler collects samples on every tick and delivers them to the subscribers
+// that are due. It only runs while it has at least one subscriber, and only
+// collects the topics that due subscribers asked for, so that the cost of
+// reading the statistics is paid once per tick regardless of how many
+// clients are connected.
+type sampler struct {
+	tick time.Duration
+	size int
+	rt   *runtimeReader
+	// fromRuntime makes the sampler fill MemStats from runtime/metrics
+	// instead of calling runtime.ReadMemStats, which stops the world.
+	fromRuntime bool
+	// sort and zero select the records of the memory profile in samples.
+	sort string
+	zero bool
+
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+	last *sample
+	stop chan struct{} // non-nil while running
+	wake chan struct{}
+}
+
+// subscription receives samples from a sampler on C. Its other fields are
+// guarded by the sampler's mutex and must be changed using update.
+type subscription struct {
+	C chan *sample
+
+	topics   topic
+	interval time.Duration
+	paused   bool
+	next     time.Time
+}
+
+func newSampler(tick time.Duration, size int) *sampler {
+	return &sampler{
+		tick: tick,
+		size: size,
+		rt:   newRuntimeReader(),
+		subs: make(map[*subscription]struct{}),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// subscribe returns a new subscription to the default topics at the
+// sampler's tick, starting the sampler if needed. The subscription is due right away.
+func (sp *sampler) subscribe() *subscription {
+	sub := &subscription{
+		C:        make(chan *sample, subscriptionBuffer),
+		topics:   topicDefault,
+		interval: sp.tick,
+	}
+	sp.mu.Lock()
+	sp.subs[sub] = struct{}{}
+	if sp.stop == nil {
+		sp.stop = make(chan struct{})
+		go sp.run(sp.stop)
+	}
+	sp.mu.Unlock()
+	sp.poke()
+	return sub
+}
+
+// unsubscribe removes sub, stopping the sampler when no subscribers are left.
+func (sp *sampler) unsubscribe(sub *subscription) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	delete(sp.subs, sub)
+	if len(sp.subs) == 0 && sp.stop != nil {
+		close(sp.stop)
+		sp.stop = nil
+	}
+}
+
+// update calls fn to change sub's settings. If sub becomes due as a result,
+// it is served without waiting for the next tick.
+func (sp *sampler) update(sub *subscription, fn func(*subscription)) {
+	sp.mu.Lock()
+	fn(sub)
+	sp.mu.Unlock()
+	sp.poke()
+}
+
+// poke makes the sampler check for due subscribers right away.
+func (sp *sampler) poke() {
+	select {
+	case sp.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sp *sampler) run(stop chan struct{}) {
+	t := time.NewTicker(sp.tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-sp.wake:
+		case <-stop:
+			return
+		}
+		now := time.Now()
+		if topics := sp.due(now); topics != 0 {
+			sp.broadcast(stop, sp.collect(topics))
+		}
+	}
+}
+
+// due returns the union of the topics of all subscribers that should receive
+// a sample collected at now.
+func (sp *sampler) due(now time.Time) topic {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	var topics topic
+	for sub := range sp.subs {
+		if sub.isDue(now, sp.tick) {
+			topics |= sub.topics
+		}
+	}
+	return topics
+}
+
+// isDue reports whether sub should receive a sample collected at now. Half a
+// tick of slack keeps ticker jitter from skipping intervals.
+func (sub *subscription) isDue(now time.Time, tick time.Duration) bool {
+	return !sub.paused && sub.topics != 0 && !now.Before(sub.next.Add(-tick/2))
+}
+
+// collect reads a new sample holding the given topics from the runtime.
+func (sp *sampler) collect(topics topic) *sample {
+	smp := sample{
+		Type:   "sample",
+		Time:   time.Now(),
+		topics: topics,
+	}
+	if topics&topicProfile != 0 {
+		smp.records = readMemProfile()
+		smp.Profiles, smp.ProfileTotals = memProfile(smp.records, sp.sort, sp.size, sp.zero)
+	}
+	if topics&topicGoroutines != 0 {
+		smp.NumGo = runtime.NumGoroutine()
+	}
+	if topics&topicContention != 0 {
+		smp.Block = contentionProfile(sp.size, runtime.BlockProfile)
+		smp.Mutex = contentionProfile(sp.size, runtime.MutexProfile)
+	}
+	var values map[string]interface{}
+	if topics&topicRuntime != 0 || (topics&topicMemStats != 0 && sp.fromRuntime) {
+		values = sp.rt.read()
+	}
+	if topics&topicRuntime != 0 {
+		smp.Runtime = values
+	}
+	if topics&topicMemStats != 0 {
+		smp.MemStats = new(runtime.MemStats)
+		if sp.fromRuntime {
+			memStatsFromRuntime(smp.MemStats, values)
+		} else {
+			runtime.ReadMemStats(smp.MemStats)
+		}
+	}
+	if topics&topicGC != 0 {
+		smp.GCStats = &debug.GCStats{PauseQuantiles: make([]time.Duration, 5)}
+		debug.ReadGCStats(smp.GCStats)
+	}
+	return &smp
+}
+
+// snapshot returns a sample holding the given topics. The sampler's latest
+// sample is reused if it holds them and is less than a tick old, otherwise a
+// new one is collected.
+func (sp *sampler) snapshot(topics topic) *sample {
+	sp.mu.Lock()
+	last := sp.last
+	sp.mu.Unlock()
+	if last != nil && last.topics&topics == topics && time.Since(last.Time) < sp.tick {
+		return last
+	}
+	smp := sp.collect(topics)
+	sp.mu.Lock()
+	if sp.last == nil || smp.Time.After(sp.last.Time) {
+		sp.last = smp
+	}
+	sp.mu.Unlock()
+	return smp
+}
+
+// broadcast sends smp to all subscribers that are due, without blocking.
+// Subscribers whose buffer is full lose their oldest sample. Samples
+// collected by a run which has since been stopped are discarded.
+func (sp *sampler) broadcast(stop chan struct{}, smp *sample) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.stop != stop {
+		return
+	}
+	sp.last = smp
+	for sub := range sp.subs {
+		if !sub.isDue(smp.Time, sp.tick) || sub.topics&^smp.topics != 0 {
+			continue
+		}
+		sub.next = smp.Time.Add(sub.interval)
+		select {
+		case sub.C <- smp:
+			continue
+		default:
+		}
+		select {
+		case <-sub.C:
+		default:
+		}
+		select {
+		case sub.C <- smp:
+		default:
+		}
+	}
+}
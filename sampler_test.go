@@ -0,0 +1,41 @@
+package memstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeTopics(t *testing.T) {
+	sp := newSampler(time.Hour, 8)
+	sub := sp.subscribe()
+	defer sp.unsubscribe(sub)
+
+	smp := receiveSample(t, sub)
+	if smp.MemStats == nil || smp.GCStats == nil {
+		t.Errorf("default sample lacks MemStats or GCStats")
+	}
+	if smp.Runtime != nil || smp.topics&(topicRuntime|topicContention) != 0 {
+		t.Errorf("default sample holds topics %v, want no runtime or contention", smp.topics.names())
+	}
+
+	sp.update(sub, func(sub *subscription) {
+		sub.topics = topicRuntime | topicContention
+		sub.next = time.Time{}
+	})
+	smp = receiveSample(t, sub)
+	if smp.Runtime == nil || smp.topics&topicContention == 0 {
+		t.Errorf("sample holds topics %v, want runtime and contention", smp.topics.names())
+	}
+}
+
+// receiveSample waits for the next sample of sub.
+func receiveSample(t *testing.T, sub *subscription) *sample {
+	t.Helper()
+	select {
+	case smp := <-sub.C:
+		return smp
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sample received")
+		return nil
+	}
+}
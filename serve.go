@@ -1,87 +1,416 @@
 // Package memstats helps you monitor a running server's memory usage, visualize Garbage
 // Collector information, run stack traces and memory profiles. To run the server, place this command
 // at the top of your application:
-// 	go memstats.Serve()
+//
+//	go memstats.Serve()
+//
 // The next time you run your application, profiling is available via websockets on port 6061,
 // and once a client is connected it will send updates every 2 seconds. Defaults can be changed
 // by passing one or more of the APIs options as params to Serve. See the examples for each option.
+// To control the server's lifetime or handle errors, use NewServer and its Start and Shutdown
+// methods instead of Serve. A Server is also an http.Handler, so the feed can be mounted on an
+// existing mux instead of listening on its own port.
 //
 // To use the provided webserver, run the command "memstat" once your applications starts
 // and has profiling enabled. To change HTTP port or connected to other sockets than default, see:
+//
 //	memstats --help
 package memstats
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"runtime"
-	"runtime/debug"
+	"sort"
+	"sync"
 	"time"
 
 	"golang.org/x/net/websocket"
 )
 
-type server struct {
-	// ListenAddr is the address that the server listens on.
-	ListenAddr string
-	// Tick is the duration between two websocket updates.
-	Tick time.Duration
-	// MemRecordSize is the maximum number of records a profile will return.
-	MemRecordSize int
+// Server is a memory monitoring server. Create one using NewServer; it is
+// configured by the options passed to NewServer and can not be changed
+// afterwards.
+type Server struct {
+	// listenAddr is the address that the server listens on. Addresses
+	// starting with "unix:" are paths of unix sockets.
+	listenAddr string
+	// socketMode, if not zero, sets the permissions of the unix socket
+	// that the server listens on.
+	socketMode os.FileMode
+	// listener, if set, is used instead of listening on listenAddr.
+	listener net.Listener
+	// systemd makes the server use the socket named systemdName that was
+	// passed by systemd, if the process was socket activated.
+	systemd     bool
+	systemdName string
+	// tick is the duration between two websocket updates. It is also the
+	// shortest interval that clients may subscribe to.
+	tick time.Duration
+	// maxTick is the longest interval that clients may subscribe to.
+	maxTick time.Duration
+	// memRecordSize is the maximum number of records a profile will return.
+	memRecordSize int
+	// profileSort is the order in which profile records are ranked. It is
+	// one of SortInUseBytes, SortAllocBytes and SortAllocObjects.
+	profileSort string
+	// profileZero includes profile records with no memory in use.
+	profileZero bool
+	// keyframeInterval is the number of messages after which clients in
+	// delta mode receive a full sample again.
+	keyframeInterval int
+	// historySize is the maximum number of samples kept in the history.
+	historySize int
+	// historyAge is the maximum age of samples kept in the history.
+	historyAge time.Duration
+	// historyBudget is the maximum number of bytes that the encoded samples
+	// in the history may take up.
+	historyBudget int
+	// prometheus enables the Prometheus metrics endpoint at /metrics.
+	prometheus bool
+	// runtimeMetrics makes the server build MemStats from the runtime/metrics
+	// package instead of calling runtime.ReadMemStats, which stops the world.
+	runtimeMetrics bool
+	// blockProfileRate, if positive, is passed to runtime.SetBlockProfileRate
+	// when the server is created.
+	blockProfileRate int
+	// mutexProfileFraction, if positive, is passed to
+	// runtime.SetMutexProfileFraction when the server is created.
+	mutexProfileFraction int
+	// leakWindow, if positive, enables the leak detector. It reports heap
+	// usage, goroutines and call stacks that grew after every garbage
+	// collection within the window.
+	leakWindow time.Duration
+	// leakMinGCs is the number of garbage collections that the leak
+	// detector must see within its window before reporting growth.
+	leakMinGCs int
+	// rules are the alert rules evaluated on every tick.
+	rules []Rule
+	// alertWebhook is the URL that alerts are posted to as JSON, if any.
+	alertWebhook string
+	// alertHandler is called with every alert, if set.
+	alertHandler func(Alert)
+	// control enables the websocket commands which run the garbage
+	// collector or change its settings.
+	control bool
+	// tokens are the bearer tokens accepted by the server, with RoleAdmin.
+	// When set, every request must carry one of them or of creds.
+	tokens []string
+	// creds map the bearer tokens accepted by the server to roles.
+	creds []Credential
+	// auditLog, if set, receives a JSON line for every request which needs
+	// more than RoleViewer or was denied.
+	auditLog io.Writer
+	// auditFile, if set, is the file that the audit log is appended to.
+	auditFile string
+	// tlsConfig, if set, makes the server serve HTTPS and WSS.
+	tlsConfig *tls.Config
+	// certFile and keyFile, if set, hold the certificate served over TLS.
+	// The files are reloaded when they change.
+	certFile string
+	keyFile  string
+	// clientCAFile, if set, holds the certificates of the authorities that
+	// client certificates must be signed by.
+	clientCAFile string
+	// allowedOrigins are the origins, such as "https://viewer.example.com",
+	// of the web pages allowed to open the websocket feed, in addition to
+	// local addresses and the server's own address.
+	allowedOrigins []string
+	// allowAnyOrigin disables the Origin check of the websocket feed.
+	allowAnyOrigin bool
+
+	mux      *http.ServeMux
+	smp      *sampler
+	hist     *history
+	leaks    *leakDetector
+	profiles profileStore
+	cpuBusy  int32 // set while a CPU profile is captured
+	auditMu  sync.Mutex
+	auditOut *os.File // the opened auditFile, closed on Shutdown
+	auditErr error    // set if auditFile could not be opened, see AuditFile
+	mu       sync.Mutex
+	ln       net.Listener
+	srv      *http.Server
+	conns    map[*client]struct{}
+	quit     chan struct{}
+	stop     sync.Once
+	done     chan struct{}
+	err      error
 }
 
-func defaults(s *server) {
-	s.ListenAddr = ":6061"
-	s.Tick = 2 * time.Second
-	s.MemRecordSize = 50
+func defaults(s *Server) {
+	s.listenAddr = ":6061"
+	s.tick = 2 * time.Second
+	s.maxTick = time.Minute
+	s.memRecordSize = 50
+	s.profileSort = SortInUseBytes
+	s.keyframeInterval = 30
+	s.historyBudget = 8 << 20
+	s.leakMinGCs = 4
 }
 
-// Serve starts a memory monitoring server. By default it listens on :6061
-func Serve(opts ...func(*server)) {
-	var s server
-	defaults(&s)
+// NewServer returns a new memory monitoring server configured using the given
+// options. The server does not listen until Start is called. If the server
+// keeps a history, detects leaks or has alert rules, sampling starts right
+// away and lasts until Shutdown, which must be called even if the server is
+// never started or Start fails.
+func NewServer(opts ...func(*Server)) *Server {
+	s := &Server{
+		conns: make(map[*client]struct{}),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	defaults(s)
 	for _, fn := range opts {
-		fn(&s)
+		fn(s)
+	}
+	if s.auditFile != "" {
+		if f, err := openAuditFile(s.auditFile); err != nil {
+			s.auditErr = err
+			s.logf("audit file: %v; requests which need more than the viewer role are refused", err)
+		} else if s.auditLog != nil {
+			s.auditOut, s.auditLog = f, io.MultiWriter(s.auditLog, f)
+		} else {
+			s.auditOut, s.auditLog = f, f
+		}
+	}
+	if s.blockProfileRate > 0 {
+		runtime.SetBlockProfileRate(s.blockProfileRate)
+	}
+	if s.mutexProfileFraction > 0 {
+		runtime.SetMutexProfileFraction(s.mutexProfileFraction)
+	}
+	s.smp = newSampler(s.tick, s.memRecordSize)
+	s.smp.fromRuntime = s.runtimeMetrics
+	s.smp.sort = s.profileSort
+	s.smp.zero = s.profileZero
+	s.mux = http.NewServeMux()
+	s.mux.Handle("/memstats-feed", websocket.Server{Handler: s.ServeMemProfile, Handshake: s.handshake})
+	s.mux.HandleFunc("/memstats-history", s.serveHistory)
+	s.mux.HandleFunc("/memstats-goroutines", s.serveGoroutines)
+	s.mux.HandleFunc("/memstats-heap", s.serveHeapProfile)
+	s.mux.HandleFunc("/memstats-profile", s.serveProfile)
+	s.mux.HandleFunc("/metrics", s.serveMetrics)
+	if s.historySize > 0 || s.historyAge > 0 {
+		s.hist = &history{
+			size:   s.historySize,
+			age:    s.historyAge,
+			budget: s.historyBudget,
+		}
+		go s.record(s.smp.subscribe())
 	}
+	if s.leakWindow > 0 {
+		s.leaks = &leakDetector{window: s.leakWindow, minGCs: s.leakMinGCs}
+		go s.detectLeaks(s.smp.subscribe())
+	}
+	if len(s.rules) > 0 {
+		go s.evaluate(s.smp.subscribe())
+	}
+	return s
+}
 
-	ln, err := net.Listen("tcp", s.ListenAddr)
-	if err != nil {
-		log.Fatalf("memstat: %s", err)
+// ServeHTTP implements http.Handler, serving the websocket feed at
+// /memstats-feed, the history of samples at /memstats-history, a dump of all
+// goroutines at /memstats-goroutines, the heap profile in pprof format at
+// /memstats-heap, captured profiles at /memstats-profile and, if enabled,
+// Prometheus metrics at /metrics. To mount the server under a prefix on an existing mux,
+// wrap it using http.StripPrefix. If the server has tokens, requests without
+// one of them are rejected.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.identify(r); !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="memstats"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
-	defer ln.Close()
-	s.ListenAddr = ln.Addr().String()
+	s.mux.ServeHTTP(w, r)
+}
 
-	mux := http.NewServeMux()
-	mux.Handle("/memstats-feed", websocket.Handler(s.ServeMemProfile))
-	if err = http.Serve(ln, mux); err != nil {
-		log.Fatalf("memstat: %s", err)
+// Serve starts a memory monitoring server. By default it listens on :6061.
+// Serve blocks for as long as the server runs. Errors are logged instead of
+// being returned; use NewServer to control the server's lifetime.
+func Serve(opts ...func(*Server)) {
+	s := NewServer(opts...)
+	if err := s.Start(context.Background()); err != nil {
+		s.logf("%s", err)
+		s.Shutdown(context.Background())
+		return
 	}
+	<-s.done
+	if s.err != nil {
+		s.logf("%s", s.err)
+	}
+	s.Shutdown(context.Background())
 }
 
-// ServeMemProfile serves the connected socket with a snapshot of
-// runtime.MemStats
-func (s server) ServeMemProfile(ws *websocket.Conn) {
-	defer ws.Close()
-	var payload struct {
-		MemStats runtime.MemStats
-		Profiles []memProfileRecord
-		GCStats  debug.GCStats
-		NumGo    int
+// Start starts listening on the server's address, unless it was given a
+// listener or was socket activated, and serves requests in the background,
+// over TLS if configured. It returns an error if the server can
+// not listen, its TLS configuration is invalid or its audit file could not be
+// opened, in which case the caller must still call Shutdown to stop sampling.
+// Once ctx is done, the server is shut down.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		return errors.New("memstats: server already started")
 	}
-	for {
-		if prof, ok := memProfile(s.MemRecordSize); ok {
-			payload.Profiles = prof
+	if s.auditErr != nil {
+		return fmt.Errorf("memstats: audit file: %w", s.auditErr)
+	}
+	cfg, err := s.newTLSConfig()
+	if err != nil {
+		return err
+	}
+	ln := s.listener
+	if ln == nil && s.systemd {
+		if ln, err = systemdListener(s.systemdName); err != nil {
+			return err
+		}
+	}
+	if ln == nil {
+		if network, addr := listenNetwork(s.listenAddr); network == "unix" {
+			ln, err = listenUnix(addr, s.socketMode)
+		} else {
+			ln, err = net.Listen(network, addr)
 		}
-		payload.NumGo = runtime.NumGoroutine()
-		runtime.ReadMemStats(&payload.MemStats)
-		debug.ReadGCStats(&payload.GCStats)
-		err := websocket.JSON.Send(ws, payload)
 		if err != nil {
-			break
+			return err
 		}
-		<-time.After(s.Tick)
 	}
+	if cfg != nil {
+		ln = tls.NewListener(ln, cfg)
+	}
+	s.ln = ln
+	s.srv = &http.Server{Handler: s}
+	go func() {
+		err := s.srv.Serve(ln)
+		if err != http.ErrServerClosed {
+			s.err = err
+		}
+		close(s.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address that the server is listening on, or nil if the
+// server has not been started. It is useful when listening on port 0.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Shutdown closes all connected websocket feeds and gracefully shuts down the
+// server, waiting for other active requests until ctx is done. The audit file
+// is closed once they are done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop.Do(func() { close(s.quit) })
+	s.mu.Lock()
+	for c := range s.conns {
+		c.ws.Close()
+		delete(s.conns, c)
+	}
+	srv := s.srv
+	s.mu.Unlock()
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+	s.auditMu.Lock()
+	if s.auditOut != nil {
+		s.auditOut.Close()
+		s.auditOut, s.auditLog = nil, nil
+	}
+	s.auditMu.Unlock()
+	return err
+}
+
+// logf logs a message prefixed with "memstats: ".
+func (s *Server) logf(format string, args ...interface{}) {
+	log.Printf("memstats: "+format, args...)
+}
+
+// track adds c to the set of live connections. It returns false if the server
+// is shutting down.
+func (s *Server) track(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.quit:
+		return false
+	default:
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c *client) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+}
+
+// publish sends msg to all connected clients without waiting for them.
+func (s *Server) publish(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		go c.post(msg)
+	}
+}
+
+// ServeMemProfile serves the connected socket with snapshots of
+// runtime.MemStats. All connected sockets share the same snapshots. Clients
+// may send requests to choose the topics, fields and interval of their
+// snapshots, or to pause and resume them.
+func (s *Server) ServeMemProfile(ws *websocket.Conn) {
+	defer ws.Close()
+	id, ok := s.identify(ws.Request())
+	if !ok {
+		return
+	}
+	c := &client{
+		s:    s,
+		ws:   ws,
+		id:   id,
+		out:  make(chan interface{}),
+		done: make(chan struct{}),
+	}
+	if !s.track(c) {
+		return
+	}
+	defer s.untrack(c)
+	c.sub = s.smp.subscribe()
+	defer s.smp.unsubscribe(c.sub)
+	c.serve()
+}
+
+// clampTick limits d to the intervals that clients may subscribe to.
+func (s *Server) clampTick(d time.Duration) time.Duration {
+	if d > s.maxTick {
+		d = s.maxTick
+	}
+	if d < s.tick {
+		d = s.tick
+	}
+	return d
 }
 
 // memProfileRecord holds information about a memory profile entry
@@ -92,54 +421,400 @@ type memProfileRecord struct {
 	InUseBytes int64
 	// Stack trace
 	Callstack []string
+	Frames    []frame
+}
+
+// Orders in which memory profile records can be sorted.
+const (
+	SortInUseBytes   = "inuse_bytes"
+	SortAllocBytes   = "alloc_bytes"
+	SortAllocObjects = "alloc_objects"
+)
+
+// profileTotals holds the totals over all records of a memory profile.
+type profileTotals struct {
+	Records    int
+	InUseBytes int64
+	InUseObjs  int64
+	AllocBytes int64
+	AllocObjs  int64
+	// Truncated is the number of records that were left out.
+	Truncated int
 }
 
-// memProfile returns a slice of memProfileRecord from the current memory profile.
-func memProfile(size int) (data []memProfileRecord, ok bool) {
-	record := make([]runtime.MemProfileRecord, size)
-	n, ok := runtime.MemProfile(record, false)
-	if !ok || n == 0 {
-		return nil, false
+// readMemProfile returns all records of the current memory profile, including
+// those with no memory in use.
+func readMemProfile() []runtime.MemProfileRecord {
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		// leave room for records added since the profile was sized
+		records := make([]runtime.MemProfileRecord, n+50)
+		var ok bool
+		n, ok = runtime.MemProfile(records, true)
+		if ok {
+			return records[:n]
+		}
 	}
-	prof := make([]memProfileRecord, len(record))
-	for i, e := range record {
+}
+
+// memProfile returns the size records of a memory profile that rank highest
+// in the given order, along with the totals over all records. Records with
+// no memory in use are left out unless zero is set.
+func memProfile(records []runtime.MemProfileRecord, order string, size int, zero bool) ([]memProfileRecord, *profileTotals) {
+	var totals profileTotals
+	idx := make([]int, 0, len(records))
+	for i := range records {
+		r := &records[i]
+		totals.InUseBytes += r.InUseBytes()
+		totals.InUseObjs += r.InUseObjects()
+		totals.AllocBytes += r.AllocBytes
+		totals.AllocObjs += r.AllocObjects
+		if zero || r.InUseBytes() != 0 {
+			idx = append(idx, i)
+		}
+	}
+	totals.Records = len(idx)
+	key := func(r *runtime.MemProfileRecord) int64 {
+		switch order {
+		case SortAllocBytes:
+			return r.AllocBytes
+		case SortAllocObjects:
+			return r.AllocObjects
+		default:
+			return r.InUseBytes()
+		}
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return key(&records[idx[i]]) > key(&records[idx[j]])
+	})
+	if len(idx) > size {
+		totals.Truncated = len(idx) - size
+		idx = idx[:size]
+	}
+	prof := make([]memProfileRecord, len(idx))
+	for i, j := range idx {
+		e := records[j]
 		prof[i] = memProfileRecord{
 			MemProfileRecord: e,
 			InUseBytes:       e.InUseBytes(),
 			InUseObjs:        e.InUseObjects(),
-			Callstack:        humanizeStack(e.Stack()),
+			Frames:           symbolize(e.Stack()),
 		}
+		prof[i].Callstack = functionNames(prof[i].Frames)
 	}
-	return prof[:n], true
+	return prof, &totals
 }
 
-// humanizeStack resolves a stracktrace to an array of function names
-func humanizeStack(stk []uintptr) []string {
-	fnpc := make([]string, len(stk))
-	var i int
-	for i = 0; i < len(stk) && stk[i] != 0; i++ {
-		fn := runtime.FuncForPC(stk[i])
-		if fn == nil {
-			continue
-		}
-		fnpc[i] = fn.Name()
+// ListenAddr sets the address that the server will listen on for HTTP
+// and WebSockets connections. An address of the form "unix:/path/to.sock"
+// listens on a unix socket instead of a TCP port, so that the server is only
+// reachable locally. A socket left behind by a previous process is removed.
+// ListenAddr is one of the options that can be provided to Serve.
+func ListenAddr(addr string) func(*Server) {
+	return func(s *Server) {
+		s.listenAddr = addr
 	}
-	return fnpc[:i]
 }
 
-// ListenAddr sets the address that the server will listen on for HTTP
-// and WebSockets connections. ListenAddr is one of the options that can
-// be provided to Serve.
-func ListenAddr(addr string) func(*server) {
-	return func(s *server) {
-		s.ListenAddr = addr
+// SocketMode sets the permissions of the unix socket that the server listens
+// on, such as 0660 to only let the owner and group of the process connect.
+// SocketMode is one of the options that can be provided to Serve.
+func SocketMode(mode os.FileMode) func(*Server) {
+	return func(s *Server) {
+		s.socketMode = mode
+	}
+}
+
+// Listener makes the server accept connections on ln instead of listening on
+// its address. The listener is closed when the server shuts down. Listener is
+// one of the options that can be provided to Serve.
+func Listener(ln net.Listener) func(*Server) {
+	return func(s *Server) {
+		s.listener = ln
+	}
+}
+
+// Systemd makes the server accept connections on a socket passed by systemd
+// socket activation, using the LISTEN_FDS and LISTEN_FDNAMES environment
+// variables. The socket is the one named name by the FileDescriptorName
+// setting of its unit, or the first one if name is empty. If the process was
+// not socket activated, the server listens on its address as usual. Systemd
+// is one of the options that can be provided to Serve.
+func Systemd(name string) func(*Server) {
+	return func(s *Server) {
+		s.systemd = true
+		s.systemdName = name
 	}
 }
 
 // Tick sets the frequency at which the websockets will send updates. Tick
 // is one of the options that can be provided to Serve.
-func Tick(d time.Duration) func(*server) {
-	return func(s *server) {
-		s.Tick = d
+func Tick(d time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.tick = d
+	}
+}
+
+// MaxTick sets the longest interval between two updates that clients may
+// subscribe to. MaxTick is one of the options that can be provided to Serve.
+func MaxTick(d time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.maxTick = d
+	}
+}
+
+// KeyframeInterval sets the number of messages after which a client that
+// subscribed in delta mode receives a full sample again. KeyframeInterval is
+// one of the options that can be provided to Serve.
+func KeyframeInterval(n int) func(*Server) {
+	return func(s *Server) {
+		s.keyframeInterval = n
+	}
+}
+
+// HistorySize makes the server keep a history of up to n samples, which is
+// sent to clients when they first subscribe and can be fetched from
+// /memstats-history. HistorySize is one of the options that can be provided
+// to Serve.
+func HistorySize(n int) func(*Server) {
+	return func(s *Server) {
+		s.historySize = n
+	}
+}
+
+// HistoryAge makes the server keep a history of the samples taken during the
+// last d. See HistorySize. HistoryAge is one of the options that can be
+// provided to Serve.
+func HistoryAge(d time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.historyAge = d
+	}
+}
+
+// HistoryBudget limits the memory used by the history to about n bytes,
+// evicting the oldest samples first. It defaults to 8MB. HistoryBudget is one
+// of the options that can be provided to Serve.
+func HistoryBudget(n int) func(*Server) {
+	return func(s *Server) {
+		s.historyBudget = n
+	}
+}
+
+// Prometheus enables an endpoint at /metrics which exposes the sampled
+// statistics in the Prometheus text exposition format. Prometheus is one of
+// the options that can be provided to Serve.
+func Prometheus() func(*Server) {
+	return func(s *Server) {
+		s.prometheus = true
+	}
+}
+
+// RuntimeMetrics makes the server read MemStats from the runtime/metrics
+// package instead of calling runtime.ReadMemStats, avoiding a stop-the-world
+// pause on every tick. MemStats fields without an equivalent metric, such as
+// PauseNs, are left empty. The metrics themselves are always available in
+// the "runtime" topic. RuntimeMetrics is one of the options that can be
+// provided to Serve.
+func RuntimeMetrics() func(*Server) {
+	return func(s *Server) {
+		s.runtimeMetrics = true
+	}
+}
+
+// BlockProfileRate enables the block profile, which is published in the
+// "contention" topic, by calling runtime.SetBlockProfileRate with rate.
+// BlockProfileRate is one of the options that can be provided to Serve.
+func BlockProfileRate(rate int) func(*Server) {
+	return func(s *Server) {
+		s.blockProfileRate = rate
+	}
+}
+
+// MutexProfileFraction enables the mutex profile, which is published in the
+// "contention" topic, by calling runtime.SetMutexProfileFraction with rate.
+// MutexProfileFraction is one of the options that can be provided to Serve.
+func MutexProfileFraction(rate int) func(*Server) {
+	return func(s *Server) {
+		s.mutexProfileFraction = rate
+	}
+}
+
+// LeakWindow enables the leak detector, which watches heap usage after each
+// garbage collection, the number of goroutines and the in use bytes of every
+// call stack. When one of them grew after every garbage collection within
+// the window, a "leaksuspect" message is pushed to all clients. LeakWindow
+// is one of the options that can be provided to Serve.
+func LeakWindow(d time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.leakWindow = d
+	}
+}
+
+// LeakMinGCs sets the number of garbage collections that the leak detector
+// must see within its window before reporting growth. It defaults to 4.
+// LeakMinGCs is one of the options that can be provided to Serve.
+func LeakMinGCs(n int) func(*Server) {
+	return func(s *Server) {
+		s.leakMinGCs = n
+	}
+}
+
+// Alerts adds rules which are evaluated on every tick. A rule fires once its
+// condition held for its number of ticks, and resolves once it stopped
+// holding for as long. Alerts are delivered to the AlertWebhook and the
+// AlertHandler. Alerts is one of the options that can be provided to Serve.
+func Alerts(rules ...Rule) func(*Server) {
+	return func(s *Server) {
+		s.rules = append(s.rules, rules...)
+	}
+}
+
+// AlertWebhook sets a URL that alerts are posted to, as a JSON encoded Alert.
+// AlertWebhook is one of the options that can be provided to Serve.
+func AlertWebhook(url string) func(*Server) {
+	return func(s *Server) {
+		s.alertWebhook = url
+	}
+}
+
+// AlertHandler sets a function that is called with every alert. Calls are
+// made from a single goroutine, in order. AlertHandler is one of the options
+// that can be provided to Serve.
+func AlertHandler(fn func(Alert)) func(*Server) {
+	return func(s *Server) {
+		s.alertHandler = fn
+	}
+}
+
+// Control enables commands which act on the runtime over the websocket feed:
+// "gc" runs a garbage collection, "freeosmemory" returns memory to the
+// operating system, and "setgcpercent" and "setmemorylimit" change the
+// garbage collector's settings. Their replies hold the memory statistics
+// from before and after the command. Unless the server has credentials, any
+// client may run them; see Credentials. Control is one of the options that
+// can be provided to Serve.
+func Control() func(*Server) {
+	return func(s *Server) {
+		s.control = true
+	}
+}
+
+// Tokens requires every request to carry one of the given bearer tokens,
+// either in an "Authorization: Bearer <token>" header or in the "token" query
+// parameter, which browsers need for websockets. Several tokens can be given
+// so that they can be rotated. Tokens have RoleAdmin. Tokens is one of the
+// options that can be provided to Serve.
+func Tokens(tokens ...string) func(*Server) {
+	return func(s *Server) {
+		s.tokens = append(s.tokens, tokens...)
+	}
+}
+
+// Credentials requires every request to carry one of the tokens of creds,
+// and gives it the credential's role. Viewers may read the feed, operators
+// may also capture CPU profiles and run the garbage collector, and admins
+// may also change its settings. Credentials is one of the options that can
+// be provided to Serve.
+func Credentials(creds ...Credential) func(*Server) {
+	return func(s *Server) {
+		s.creds = append(s.creds, creds...)
+	}
+}
+
+// AuditLog writes a JSON line to w for every request which needs more than
+// the viewer role or was denied, recording who made it, when, its arguments
+// and its result. AuditLog is one of the options that can be provided to
+// Serve.
+func AuditLog(w io.Writer) func(*Server) {
+	return func(s *Server) {
+		s.auditLog = w
+	}
+}
+
+// AuditFile appends the audit log to the file at path, creating it if needed.
+// See AuditLog. If the file can not be opened, Start fails, and a server
+// mounted using ServeHTTP refuses requests which need more than the viewer
+// role. AuditFile is one of the options that can be provided to Serve.
+func AuditFile(path string) func(*Server) {
+	return func(s *Server) {
+		s.auditFile = path
+	}
+}
+
+// TLS makes the server serve HTTPS and WSS using the certificate and key in
+// the given PEM files. The files are checked for changes every few seconds,
+// so that a renewed certificate is picked up without restarting. TLS is one
+// of the options that can be provided to Serve.
+func TLS(certFile, keyFile string) func(*Server) {
+	return func(s *Server) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// TLSConfig makes the server serve HTTPS and WSS using cfg. It can be combined
+// with TLS, in which case the certificate is taken from TLS's files. TLSConfig
+// is one of the options that can be provided to Serve.
+func TLSConfig(cfg *tls.Config) func(*Server) {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// ClientCAs makes the server require client certificates signed by one of
+// the authorities in the given PEM file, for mutual TLS. It must be used
+// along with TLS or TLSConfig. ClientCAs is one of the options that can be
+// provided to Serve.
+func ClientCAs(file string) func(*Server) {
+	return func(s *Server) {
+		s.clientCAFile = file
+	}
+}
+
+// AllowOrigins adds origins, such as "https://viewer.example.com", to those
+// allowed to open the websocket feed. By default only web pages served from
+// a local address or from the literal address that the server listens on are
+// allowed, so that other web pages can not read the feed. Pages served under
+// a host name, such as that of a reverse proxy, must be allowed explicitly.
+// AllowOrigins is one of the options that can be provided to Serve.
+func AllowOrigins(origins ...string) func(*Server) {
+	return func(s *Server) {
+		s.allowedOrigins = append(s.allowedOrigins, origins...)
+	}
+}
+
+// AllowAnyOrigin disables the Origin check of the websocket feed, for clients
+// which send an arbitrary Origin. Clients which send none are always allowed.
+// AllowAnyOrigin is one of the options that can be provided to Serve.
+func AllowAnyOrigin() func(*Server) {
+	return func(s *Server) {
+		s.allowAnyOrigin = true
+	}
+}
+
+// MemRecordSize sets the number of top memory profile records that are sent.
+// MemRecordSize is one of the options that can be provided to Serve.
+func MemRecordSize(n int) func(*Server) {
+	return func(s *Server) {
+		s.memRecordSize = n
+	}
+}
+
+// ProfileSort sets the order in which memory profile records are ranked
+// before the top MemRecordSize are sent: SortInUseBytes, the default,
+// SortAllocBytes or SortAllocObjects. Clients may choose their own order.
+// ProfileSort is one of the options that can be provided to Serve.
+func ProfileSort(order string) func(*Server) {
+	return func(s *Server) {
+		s.profileSort = order
+	}
+}
+
+// ProfileZero includes memory profile records with no memory in use, which
+// are left out by default. ProfileZero is one of the options that can be
+// provided to Serve.
+func ProfileZero() func(*Server) {
+	return func(s *Server) {
+		s.profileZero = true
 	}
 }
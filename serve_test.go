@@ -0,0 +1,93 @@
+package memstats
+
+import (
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestServeStopsOnError(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	before := runtime.NumGoroutine()
+	// the address is in use, so Serve returns right away
+	Serve(ListenAddr(ln.Addr().String()), HistorySize(5), LeakWindow(time.Minute), Alerts(HeapAllocAbove(1<<40, 1)))
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// profileRecords are memory profile records told apart by AllocBytes.
+var profileRecords = []runtime.MemProfileRecord{
+	{AllocBytes: 100, AllocObjects: 10},
+	{AllocBytes: 300, FreeBytes: 250, AllocObjects: 30, FreeObjects: 25},
+	{AllocBytes: 1000, FreeBytes: 1000, AllocObjects: 2, FreeObjects: 2},
+	{AllocBytes: 200, AllocObjects: 40},
+}
+
+func TestMemProfile(t *testing.T) {
+	for _, tt := range []struct {
+		order     string
+		size      int
+		zero      bool
+		want      []int64 // AllocBytes of the records
+		records   int
+		truncated int
+	}{
+		{SortInUseBytes, 10, false, []int64{200, 100, 300}, 3, 0},
+		{SortInUseBytes, 10, true, []int64{200, 100, 300, 1000}, 4, 0},
+		{SortAllocBytes, 10, true, []int64{1000, 300, 200, 100}, 4, 0},
+		{SortAllocBytes, 10, false, []int64{300, 200, 100}, 3, 0},
+		{SortAllocObjects, 10, true, []int64{200, 300, 100, 1000}, 4, 0},
+		{"", 10, false, []int64{200, 100, 300}, 3, 0},
+		{SortInUseBytes, 2, false, []int64{200, 100}, 3, 1},
+		{SortAllocBytes, 1, true, []int64{1000}, 4, 3},
+		{SortInUseBytes, 3, false, []int64{200, 100, 300}, 3, 0},
+	} {
+		prof, totals := memProfile(profileRecords, tt.order, tt.size, tt.zero)
+		got := []int64{}
+		for _, r := range prof {
+			got = append(got, r.AllocBytes)
+			if r.InUseBytes != r.MemProfileRecord.InUseBytes() || r.InUseObjs != r.MemProfileRecord.InUseObjects() {
+				t.Errorf("record %d: in use %d bytes and %d objects, want %d and %d", r.AllocBytes,
+					r.InUseBytes, r.InUseObjs, r.MemProfileRecord.InUseBytes(), r.MemProfileRecord.InUseObjects())
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q size %d zero %v: got records %v, want %v", tt.order, tt.size, tt.zero, got, tt.want)
+		}
+		want := profileTotals{Records: tt.records, InUseBytes: 350, InUseObjs: 55, AllocBytes: 1600, AllocObjs: 82, Truncated: tt.truncated}
+		if *totals != want {
+			t.Errorf("%q size %d zero %v: got totals %+v, want %+v", tt.order, tt.size, tt.zero, *totals, want)
+		}
+	}
+}
+
+func TestProfileSizeClamped(t *testing.T) {
+	c := &client{s: &Server{profileSort: SortInUseBytes, memRecordSize: 3}}
+	for _, tt := range []struct {
+		size, want int
+	}{
+		{2, 2},
+		{0, 2}, // zero keeps the current size
+		{-1, 2},
+		{10, 3},
+		{3, 3},
+	} {
+		c.setProfileOptions(subscribeArgs{ProfileSize: tt.size})
+		if got := c.profileOptions().size; got != tt.want {
+			t.Errorf("ProfileSize %d: got size %d, want %d", tt.size, got, tt.want)
+		}
+	}
+	if c.profile != nil {
+		t.Errorf("options matching the server's are kept: %+v", *c.profile)
+	}
+}
@@ -0,0 +1,76 @@
+package memstats
+
+import (
+	"runtime"
+	"sync"
+)
+
+// frame is a function call in a stack trace.
+type frame struct {
+	Function string
+	File     string
+	Line     int
+	// Inlined is set if the call was inlined into its caller, which is the
+	// next frame.
+	Inlined bool
+}
+
+// symbolCache caches the frames that program counters expand to. Program
+// counters are the same for the lifetime of the process, so the cache is
+// shared and never evicted; it is bounded by the size of the program.
+type symbolCache struct {
+	mu     sync.Mutex
+	frames map[uintptr][]frame
+}
+
+var symbols = symbolCache{frames: make(map[uintptr][]frame)}
+
+// symbolize resolves a stack trace of return addresses, as found in profile
+// records, to its frames, innermost first. Inlined calls are expanded into
+// frames of their own.
+func symbolize(stk []uintptr) []frame {
+	var frames []frame
+	for _, pc := range stk {
+		if pc == 0 {
+			break
+		}
+		frames = append(frames, symbols.expand(pc)...)
+	}
+	return frames
+}
+
+// expand returns the frames of the call that returns to pc.
+func (sc *symbolCache) expand(pc uintptr) []frame {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if frames, ok := sc.frames[pc]; ok {
+		return frames
+	}
+	var frames []frame
+	it := runtime.CallersFrames([]uintptr{pc})
+	for {
+		f, more := it.Next()
+		if f.Function != "" {
+			frames = append(frames, frame{
+				Function: f.Function,
+				File:     f.File,
+				Line:     f.Line,
+				Inlined:  f.Func == nil,
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	sc.frames[pc] = frames
+	return frames
+}
+
+// functionNames returns the names of the functions called in frames.
+func functionNames(frames []frame) []string {
+	names := make([]string, len(frames))
+	for i, f := range frames {
+		names[i] = f.Function
+	}
+	return names
+}
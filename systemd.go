@@ -0,0 +1,43 @@
+package memstats
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// systemdListener returns the listening socket named name that was passed
+// by systemd using the LISTEN_FDS and LISTEN_FDNAMES environment variables.
+// An empty name selects the first socket. It returns nil if the process was
+// not socket activated.
+func systemdListener(name string) (net.Listener, error) {
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		fdName := ""
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if name != "" && fdName != name {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), fdName)
+		// FileListener duplicates the descriptor, so that the original
+		// can be closed.
+		ln, err := net.FileListener(f)
+		f.Close()
+		return ln, err
+	}
+	return nil, errors.New("memstats: no socket named " + strconv.Quote(name) + " was passed by systemd")
+}
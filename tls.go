@@ -0,0 +1,108 @@
+package memstats
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes.
+const certCheckInterval = 10 * time.Second
+
+// certReloader loads a certificate from a pair of files, and reloads it when
+// they change so that renewed certificates are served without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// load reads the certificate files if they changed since they were last
+// read.
+func (cr *certReloader) load() error {
+	mt, err := modTime(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	if cr.cert != nil && mt.Equal(cr.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert, cr.modTime = &cert, mt
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. If the certificate
+// files can not be reloaded, the previous certificate is served.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if now := time.Now(); now.Sub(cr.checked) >= certCheckInterval {
+		cr.checked = now
+		if err := cr.load(); err != nil && cr.cert == nil {
+			return nil, err
+		}
+	}
+	return cr.cert, nil
+}
+
+// modTime returns the latest modification time of the files.
+func modTime(files ...string) (time.Time, error) {
+	var mt time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(mt) {
+			mt = fi.ModTime()
+		}
+	}
+	return mt, nil
+}
+
+// newTLSConfig returns the TLS configuration of the server, or nil if it serves
+// plain HTTP.
+func (s *Server) newTLSConfig() (*tls.Config, error) {
+	if s.tlsConfig == nil && s.certFile == "" && s.clientCAFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.tlsConfig != nil {
+		cfg = s.tlsConfig.Clone()
+	}
+	if s.certFile != "" || s.keyFile != "" {
+		cr := certReloader{certFile: s.certFile, keyFile: s.keyFile}
+		if err := cr.load(); err != nil {
+			return nil, err
+		}
+		cr.checked = time.Now()
+		cfg.GetCertificate = cr.getCertificate
+	}
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return nil, errors.New("memstats: TLS needs a certificate")
+	}
+	if s.clientCAFile != "" {
+		pem, err := os.ReadFile(s.clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("memstats: no certificates found in " + s.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,57 @@
+package memstats
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// listenNetwork returns the network and address to listen on for addr, which
+// is either a TCP address or "unix:" followed by the path of a socket.
+func listenNetwork(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	return "tcp", addr
+}
+
+// listenUnix listens on the unix socket at path, removing a stale socket left
+// behind by a previous process. The socket's permissions are set to mode, if
+// not zero.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path if no process listens on it.
+// Files which are not sockets are left alone.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.New("memstats: " + path + " exists and is not a socket")
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("memstats: " + path + " is in use")
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,91 @@
+package memstats
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenUnixStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memstats.sock")
+	old, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as after a crash, the socket is left behind
+	old.SetUnlinkOnClose(false)
+	old.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := listenUnix(path, 0)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenUnixInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memstats.sock")
+	live, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	if ln, err := listenUnix(path, 0); err == nil {
+		ln.Close()
+		t.Fatal("listened on a socket in use")
+	} else if !strings.Contains(err.Error(), "in use") {
+		t.Errorf("got error %v, want the socket in use", err)
+	}
+	// the live socket is still reachable
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestListenUnixNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memstats.sock")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if ln, err := listenUnix(path, 0); err == nil {
+		ln.Close()
+		t.Fatal("listened in place of a regular file")
+	} else if !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("got error %v, want the file not to be a socket", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("file holds %q, %v, want it left alone", data, err)
+	}
+}
+
+func TestListenUnixMode(t *testing.T) {
+	for _, mode := range []os.FileMode{0600, 0660} {
+		path := filepath.Join(t.TempDir(), "memstats.sock")
+		ln, err := listenUnix(path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Lstat(path)
+		ln.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != mode {
+			t.Errorf("got mode %v, want a socket with permissions %v", fi.Mode(), mode)
+		}
+	}
+}